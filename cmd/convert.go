@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/qinchy/hellogo/pkg/compress"
+	"github.com/qinchy/hellogo/pkg/convert"
+	"io"
+	"os"
+)
+
+// convertCmd 命令行格式转换
+// hellogo convert -from csv -to json -schema id:int,name:string -in data.csv -out data.json
+func convertCmd(args []string) int {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	fromName := fs.String("from", "", "输入格式：csv、json、jsonl、yaml、xml、protobuf")
+	toName := fs.String("to", "", "输出格式：csv、json、jsonl、yaml、xml、protobuf")
+	schemaText := fs.String("schema", "", "列类型提示，如 id:int,name:string,price:float")
+	in := fs.String("in", "-", "输入文件，-表示标准输入，压缩文件自动解压")
+	out := fs.String("out", "-", "输出文件，-表示标准输出")
+	codecName := fs.String("compress", "none", "输出压缩格式：none、gzip、zstd")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := runConvert(*fromName, *toName, *schemaText, *in, *out, *codecName); err != nil {
+		fmt.Fprintln(os.Stderr, "convert:", err)
+		return 1
+	}
+	return 0
+}
+
+func runConvert(fromName, toName, schemaText, in, out, codecName string) error {
+	from, err := convert.ParseFormat(fromName)
+	if err != nil {
+		return err
+	}
+	to, err := convert.ParseFormat(toName)
+	if err != nil {
+		return err
+	}
+	schema, err := convert.ParseSchema(schemaText)
+	if err != nil {
+		return err
+	}
+	codec, err := compress.ParseCodec(codecName)
+	if err != nil {
+		return err
+	}
+
+	var src io.Reader = os.Stdin
+	if in != "-" {
+		f, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		src = f
+	}
+	zr, _, err := compress.NewReader(src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	var dst io.WriteCloser = os.Stdout
+	if out != "-" {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		dst = f
+	}
+	zw, err := compress.NewWriter(dst, codec)
+	if err != nil {
+		return err
+	}
+
+	n, err := convert.Convert(from, to, zr, zw, schema)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if out != "-" {
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "converted %d records\n", n)
+	return nil
+}
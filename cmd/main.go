@@ -7,7 +7,6 @@ import (
 	"github.com/qinchy/hellogo/gin/handler"
 	"github.com/qinchy/hellogo/pkg/read"
 	"github.com/qinchy/hellogo/pkg/scheduler"
-	"github.com/qinchy/hellogo/pkg/write"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,6 +14,16 @@ import (
 )
 
 func main() {
+	// 子命令，执行完直接退出，不启动服务器
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "convert":
+			os.Exit(convertCmd(os.Args[2:]))
+		case "user":
+			os.Exit(userCmd(os.Args[2:]))
+		}
+	}
+
 	Logger.Info("开始初始化任务引擎...")
 	schedule()
 	Logger.Info("任务引擎初始化完成")
@@ -47,7 +56,7 @@ func main() {
 	Logger.Info("服务器启动完成")
 
 	// 定义一个关闭服务器接受信号的通道
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	// 这个通道只接收os.Interrupt信号
 	signal.Notify(quit, os.Interrupt)
 	// 如果从通道中接收信号，就调用srv的shutdown优雅的关闭服务器
@@ -65,6 +74,11 @@ func main() {
 
 func schedule() {
 	go read.ReadFile()
-	go write.WriteFile()
 	go scheduler.PrintTimeEveryMinute()
+	go scheduler.Every(time.Hour, handler.CleanExpiredTusUploads)
+	go scheduler.Every(Conf.UploadGCInterval, handler.CollectUploadGarbage)
+	go scheduler.Every(time.Minute, handler.CleanUploadProgress)
+	go scheduler.Every(10*time.Second, handler.ReloadUsers)
+	go scheduler.Every(time.Hour, handler.CleanExpiredRefreshTokens)
+	go scheduler.Every(10*time.Minute, handler.CleanExpiredSessions)
 }
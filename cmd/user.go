@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/account"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const userUsage = `用法：hellogo user [-file users.json] <命令> [参数]
+
+命令：
+  list              列出全部用户
+  add <name>        创建用户，密码从标准输入读取
+  passwd <name>     修改密码，密码从标准输入读取
+  disable <name>    禁用用户
+  enable <name>     启用用户
+  roles <name> [role,...]
+                    设置用户的角色，省略角色时清空
+  link <name> <issuer> <subject>
+                    关联OIDC身份（ID令牌的iss和sub），关联后才能通过OIDC登录
+  unlink <name>     取消关联OIDC身份
+  delete <name>     删除用户
+
+echo 'secret-password' | hellogo user add foo
+hellogo user roles foo admin
+hellogo user link foo https://idp.example.com/realms/hellogo 248289761001`
+
+// userCmd 命令行管理用户，运行中的服务器会定时重新加载用户文件
+func userCmd(args []string) int {
+	fs := flag.NewFlagSet("user", flag.ContinueOnError)
+	file := fs.String("file", Conf.UserFile, "用户文件")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), userUsage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	store, err := account.Open(*file, Conf.PasswordCost)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "user:", err)
+		return 1
+	}
+	if err := runUser(store, fs.Arg(0), fs.Args()[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "user:", err)
+		if errors.Is(err, errUserUsage) {
+			fs.Usage()
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+var errUserUsage = errors.New("invalid arguments")
+
+func runUser(store *account.Store, command string, args []string, in io.Reader, out io.Writer) error {
+	if command == "list" {
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSTATUS\tROLES\tUPDATED")
+		for _, u := range store.List() {
+			status := "active"
+			if u.Disabled {
+				status = "disabled"
+			} else if u.PasswordHash == "" {
+				status = "external"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", u.Name, status, strings.Join(u.Roles, ","), u.UpdatedAt.Format(time.RFC3339))
+		}
+		return w.Flush()
+	}
+
+	if command == "roles" {
+		if len(args) < 1 || len(args) > 2 {
+			return errUserUsage
+		}
+		var roles []string
+		if len(args) == 2 && args[1] != "" {
+			roles = strings.Split(args[1], ",")
+		}
+		for _, role := range roles {
+			if !Policy.HasRole(role) {
+				return fmt.Errorf("unknown role %q, available roles: %s", role, strings.Join(Policy.RoleNames(), ", "))
+			}
+		}
+		if err := store.SetRoles(args[0], roles); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "已设置用户%s的角色：%s\n", args[0], strings.Join(roles, ","))
+		return nil
+	}
+
+	if command == "link" {
+		if len(args) != 3 || args[1] == "" || args[2] == "" {
+			return errUserUsage
+		}
+		if err := store.Link(args[0], args[1], args[2]); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "已为用户%s关联OIDC身份：%s %s\n", args[0], args[1], args[2])
+		return nil
+	}
+
+	if len(args) != 1 {
+		return errUserUsage
+	}
+	name := args[0]
+	switch command {
+	case "add":
+		password, err := readPassword(in)
+		if err != nil {
+			return err
+		}
+		if _, err := store.Create(name, password); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "已创建用户%s\n", name)
+	case "passwd":
+		password, err := readPassword(in)
+		if err != nil {
+			return err
+		}
+		if err := store.SetPassword(name, password); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "已修改用户%s的密码\n", name)
+	case "disable", "enable":
+		if err := store.SetDisabled(name, command == "disable"); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "已%s用户%s\n", map[string]string{"disable": "禁用", "enable": "启用"}[command], name)
+	case "unlink":
+		if err := store.Link(name, "", ""); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "已取消用户%s的OIDC身份关联\n", name)
+	case "delete":
+		if err := store.Delete(name); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "已删除用户%s\n", name)
+	default:
+		return errUserUsage
+	}
+	return nil
+}
+
+// readPassword 从标准输入读取一行作为密码，不通过参数传递以免留在shell历史和进程列表中
+func readPassword(in io.Reader) (string, error) {
+	if f, ok := in.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprint(os.Stderr, "密码：")
+		}
+	}
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", errors.New("password is required on stdin")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
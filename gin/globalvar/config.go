@@ -0,0 +1,268 @@
+package globalvar
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config 应用配置，默认值可以通过环境变量覆盖
+type Config struct {
+	// InboxDir 待读取文件所在目录
+	InboxDir string
+
+	// DeadLetterFile 解析或校验失败的记录写入的死信文件
+	DeadLetterFile string
+
+	// ConvertMaxBodySize 格式转换请求体的大小上限（字节），压缩的请求体解压后同样受此限制
+	ConvertMaxBodySize int64
+
+	// OutputDir 读取通过的记录写出的目录，每个输入文件对应一个输出文件和清单
+	OutputDir string
+
+	// OutputCompression 写出文件的压缩格式：none、gzip、zstd
+	OutputCompression string
+
+	// QuarantineDir 校验和与清单不一致的文件会被移到这里
+	QuarantineDir string
+
+	// RequireManifest 为true时拒绝读取没有清单的文件
+	RequireManifest bool
+
+	// UploadRoot 上传文件保存的根目录
+	UploadRoot string
+
+	// UploadMaxFileSize 上传单个文件的默认大小上限（字节）
+	UploadMaxFileSize int64
+
+	// UploadMaxFiles 一次请求上传文件数的默认上限
+	UploadMaxFiles int
+
+	// UploadAllowTypes 允许上传的文件类型，逗号分隔，支持image/*，为空时不限制
+	UploadAllowTypes string
+
+	// UploadDenyTypes 禁止上传的文件类型，优先于UploadAllowTypes
+	UploadDenyTypes string
+
+	// UploadStrictTypes 为true时要求文件内容与扩展名、声明的Content-Type一致
+	UploadStrictTypes bool
+
+	// Scanner 上传文件的病毒扫描器：clamd、eicar、none。
+	// 默认clamd，clamd不可用时拒绝上传；eicar只能识别EICAR测试文件，仅用于测试
+	Scanner string
+
+	// ClamdAddress clamd的地址，如 tcp://127.0.0.1:3310 或 unix:///var/run/clamav/clamd.ctl
+	ClamdAddress string
+
+	// ScanTimeout 单个文件的扫描超时时间
+	ScanTimeout time.Duration
+
+	// ScanQuarantineDir 感染文件的隔离目录
+	ScanQuarantineDir string
+
+	// ThumbnailSizes 上传图片时生成的缩略图尺寸（长边像素），逗号分隔
+	ThumbnailSizes string
+
+	// ImageMaxPixels 生成缩略图的图片像素数上限，超过时只保存原图
+	ImageMaxPixels int64
+
+	// UploadProgressRetention 上传结束后保留进度的时间
+	UploadProgressRetention time.Duration
+
+	// UploadGCInterval 回收未被引用的上传内容的间隔
+	UploadGCInterval time.Duration
+
+	// FetchConfig /fetchfromreader的上游白名单配置文件（yaml），不存在时只允许默认的图片地址
+	FetchConfig string
+
+	// FetchCacheSize 上游资源内存缓存的容量（字节），为0时不缓存
+	FetchCacheSize int64
+
+	// FetchCacheDir 上游资源磁盘缓存的目录，为空时只使用内存缓存
+	FetchCacheDir string
+
+	// FetchCacheDiskSize 上游资源磁盘缓存的容量（字节）
+	FetchCacheDiskSize int64
+
+	// OutboundFailureThreshold 访问同一上游连续失败多少次后熔断
+	OutboundFailureThreshold int
+
+	// OutboundOpenTimeout 熔断后多久放行探测请求
+	OutboundOpenTimeout time.Duration
+
+	// OutboundMaxRetries 幂等请求访问上游失败后的最大重试次数
+	OutboundMaxRetries int
+
+	// OutboundRetryBackoff 第一次重试前的等待时间，之后按指数增长
+	OutboundRetryBackoff time.Duration
+
+	// TusDir 断点续传上传未完成时数据的保存目录
+	TusDir string
+
+	// TusMaxSize 断点续传上传的最大长度（字节）
+	TusMaxSize int64
+
+	// TusExpiry 断点续传上传在没有新数据时的过期时间
+	TusExpiry time.Duration
+
+	// UserFile 用户文件，保存用户名和bcrypt密码哈希
+	UserFile string
+
+	// PasswordCost 新密码的bcrypt计算强度
+	PasswordCost int
+
+	// JWTKeys JWT签名密钥的配置文件（yaml），不存在时使用随机生成的HS256密钥，重启后令牌失效
+	JWTKeys string
+
+	// JWTIssuer 访问令牌的iss
+	JWTIssuer string
+
+	// JWTAudience 访问令牌的aud
+	JWTAudience string
+
+	// JWTAccessTTL 访问令牌的有效期
+	JWTAccessTTL time.Duration
+
+	// RefreshTokenFile 刷新令牌文件，只保存令牌的哈希
+	RefreshTokenFile string
+
+	// RefreshTokenTTL 刷新令牌的有效期，每次轮换后重新计算
+	RefreshTokenTTL time.Duration
+
+	// SessionStore 会话存储：memory、file、cookie
+	SessionStore string
+
+	// SessionDir file存储保存会话的目录
+	SessionDir string
+
+	// SessionSecret cookie存储加密和签名的密钥，至少32字节，为空时随机生成，重启后会话失效
+	SessionSecret string
+
+	// SessionCookieName 会话cookie的名称
+	SessionCookieName string
+
+	// SessionCookieDomain 会话cookie的Domain，为空时只发给当前主机
+	SessionCookieDomain string
+
+	// SessionCookieSecure 会话cookie是否只通过HTTPS发送
+	SessionCookieSecure bool
+
+	// SessionCookieSameSite 会话cookie的SameSite：lax、strict、none
+	SessionCookieSameSite string
+
+	// SessionIdleTimeout 会话超过该时间没有访问时过期
+	SessionIdleTimeout time.Duration
+
+	// SessionAbsoluteTimeout 会话从创建开始的最长有效期
+	SessionAbsoluteTimeout time.Duration
+
+	// RBACPolicy 角色和权限的配置文件（yaml），不存在时admin拥有全部权限，operator只读
+	RBACPolicy string
+
+	// AuditLog 审计日志文件
+	AuditLog string
+
+	// APIKeyFile API key文件，只保存key的哈希
+	APIKeyFile string
+
+	// APIKeyDefaultTTL 创建API key时没有指定过期时间时的有效期
+	APIKeyDefaultTTL time.Duration
+
+	// OIDCConfig OpenID Connect登录配置文件，不存在时不启用OIDC登录
+	OIDCConfig string
+}
+
+// Conf 全局配置
+var Conf = loadConfig()
+
+// loadConfig 从环境变量加载配置
+func loadConfig() *Config {
+	return &Config{
+		InboxDir:                 getEnv("HELLOGO_INBOX_DIR", "./data/inbox"),
+		DeadLetterFile:           getEnv("HELLOGO_DEADLETTER_FILE", "./data/deadletter.jsonl"),
+		ConvertMaxBodySize:       getEnvInt64("HELLOGO_CONVERT_MAX_BODY_SIZE", 64<<20),
+		OutputDir:                getEnv("HELLOGO_OUTPUT_DIR", "./data/outbox"),
+		OutputCompression:        getEnv("HELLOGO_OUTPUT_COMPRESSION", "none"),
+		QuarantineDir:            getEnv("HELLOGO_QUARANTINE_DIR", "./data/quarantine"),
+		RequireManifest:          getEnvBool("HELLOGO_REQUIRE_MANIFEST", false),
+		UploadRoot:               getEnv("HELLOGO_UPLOAD_ROOT", "./data/uploads"),
+		UploadMaxFileSize:        getEnvInt64("HELLOGO_UPLOAD_MAX_FILE_SIZE", 32<<20),
+		UploadMaxFiles:           int(getEnvInt64("HELLOGO_UPLOAD_MAX_FILES", 10)),
+		UploadAllowTypes:         getEnv("HELLOGO_UPLOAD_ALLOW_TYPES", ""),
+		UploadDenyTypes:          getEnv("HELLOGO_UPLOAD_DENY_TYPES", "application/x-executable,application/x-msdownload,text/x-shellscript"),
+		UploadStrictTypes:        getEnvBool("HELLOGO_UPLOAD_STRICT_TYPES", true),
+		Scanner:                  getEnv("HELLOGO_SCANNER", "clamd"),
+		ClamdAddress:             getEnv("HELLOGO_CLAMD_ADDRESS", "tcp://127.0.0.1:3310"),
+		ScanTimeout:              getEnvDuration("HELLOGO_SCAN_TIMEOUT", 2*time.Minute),
+		ScanQuarantineDir:        getEnv("HELLOGO_SCAN_QUARANTINE_DIR", "./data/quarantine/uploads"),
+		ThumbnailSizes:           getEnv("HELLOGO_THUMBNAIL_SIZES", "128,256,512"),
+		ImageMaxPixels:           getEnvInt64("HELLOGO_IMAGE_MAX_PIXELS", 40_000_000),
+		UploadProgressRetention:  getEnvDuration("HELLOGO_UPLOAD_PROGRESS_RETENTION", 5*time.Minute),
+		UploadGCInterval:         getEnvDuration("HELLOGO_UPLOAD_GC_INTERVAL", 6*time.Hour),
+		FetchConfig:              getEnv("HELLOGO_FETCH_CONFIG", "./conf/fetch.yaml"),
+		FetchCacheSize:           getEnvInt64("HELLOGO_FETCH_CACHE_SIZE", 64<<20),
+		FetchCacheDir:            getEnv("HELLOGO_FETCH_CACHE_DIR", ""),
+		FetchCacheDiskSize:       getEnvInt64("HELLOGO_FETCH_CACHE_DISK_SIZE", 1<<30),
+		OutboundFailureThreshold: int(getEnvInt64("HELLOGO_OUTBOUND_FAILURE_THRESHOLD", 5)),
+		OutboundOpenTimeout:      getEnvDuration("HELLOGO_OUTBOUND_OPEN_TIMEOUT", 30*time.Second),
+		OutboundMaxRetries:       int(getEnvInt64("HELLOGO_OUTBOUND_MAX_RETRIES", 2)),
+		OutboundRetryBackoff:     getEnvDuration("HELLOGO_OUTBOUND_RETRY_BACKOFF", 100*time.Millisecond),
+		TusDir:                   getEnv("HELLOGO_TUS_DIR", "./data/tus"),
+		TusMaxSize:               getEnvInt64("HELLOGO_TUS_MAX_SIZE", 10<<30),
+		TusExpiry:                getEnvDuration("HELLOGO_TUS_EXPIRY", 24*time.Hour),
+		UserFile:                 getEnv("HELLOGO_USER_FILE", "./data/users.json"),
+		PasswordCost:             int(getEnvInt64("HELLOGO_PASSWORD_COST", 10)),
+		JWTKeys:                  getEnv("HELLOGO_JWT_KEYS", "./conf/jwt.yaml"),
+		JWTIssuer:                getEnv("HELLOGO_JWT_ISSUER", "hellogo"),
+		JWTAudience:              getEnv("HELLOGO_JWT_AUDIENCE", "hellogo"),
+		JWTAccessTTL:             getEnvDuration("HELLOGO_JWT_ACCESS_TTL", 15*time.Minute),
+		RefreshTokenFile:         getEnv("HELLOGO_REFRESH_TOKEN_FILE", "./data/refresh_tokens.json"),
+		RefreshTokenTTL:          getEnvDuration("HELLOGO_REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		SessionStore:             getEnv("HELLOGO_SESSION_STORE", "memory"),
+		SessionDir:               getEnv("HELLOGO_SESSION_DIR", "./data/sessions"),
+		SessionSecret:            getEnv("HELLOGO_SESSION_SECRET", ""),
+		SessionCookieName:        getEnv("HELLOGO_SESSION_COOKIE_NAME", "hellogo_session"),
+		SessionCookieDomain:      getEnv("HELLOGO_SESSION_COOKIE_DOMAIN", ""),
+		SessionCookieSecure:      getEnvBool("HELLOGO_SESSION_COOKIE_SECURE", true),
+		SessionCookieSameSite:    getEnv("HELLOGO_SESSION_COOKIE_SAMESITE", "lax"),
+		SessionIdleTimeout:       getEnvDuration("HELLOGO_SESSION_IDLE_TIMEOUT", 30*time.Minute),
+		SessionAbsoluteTimeout:   getEnvDuration("HELLOGO_SESSION_ABSOLUTE_TIMEOUT", 12*time.Hour),
+		RBACPolicy:               getEnv("HELLOGO_RBAC_POLICY", "./conf/rbac.yaml"),
+		AuditLog:                 getEnv("HELLOGO_AUDIT_LOG", "./data/audit.jsonl"),
+		APIKeyFile:               getEnv("HELLOGO_API_KEY_FILE", "./data/apikeys.json"),
+		APIKeyDefaultTTL:         getEnvDuration("HELLOGO_API_KEY_DEFAULT_TTL", 90*24*time.Hour),
+		OIDCConfig:               getEnv("HELLOGO_OIDC_CONFIG", "./conf/oidc.yaml"),
+	}
+}
+
+// getEnv 读取环境变量，为空时返回默认值
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// getEnvBool 读取布尔类型的环境变量，为空或无法解析时返回默认值
+func getEnvBool(key string, def bool) bool {
+	if b, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return b
+	}
+	return def
+}
+
+// getEnvInt64 读取整数类型的环境变量，为空或无法解析时返回默认值
+func getEnvInt64(key string, def int64) int64 {
+	if n, err := strconv.ParseInt(os.Getenv(key), 10, 64); err == nil {
+		return n
+	}
+	return def
+}
+
+// getEnvDuration 读取时间间隔类型的环境变量，如 "30s"、"24h"
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return d
+	}
+	return def
+}
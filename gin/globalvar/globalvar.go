@@ -1,13 +1,34 @@
 package globalvar
 
 import (
+	"context"
+	"crypto/rand"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
 	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
+	"github.com/qinchy/hellogo/pkg/account"
+	"github.com/qinchy/hellogo/pkg/apikey"
+	"github.com/qinchy/hellogo/pkg/audit"
+	"github.com/qinchy/hellogo/pkg/compress"
+	"github.com/qinchy/hellogo/pkg/deadletter"
+	"github.com/qinchy/hellogo/pkg/fetch"
+	"github.com/qinchy/hellogo/pkg/jwt"
+	"github.com/qinchy/hellogo/pkg/oidc"
+	"github.com/qinchy/hellogo/pkg/outbound"
+	"github.com/qinchy/hellogo/pkg/progress"
+	"github.com/qinchy/hellogo/pkg/rbac"
+	"github.com/qinchy/hellogo/pkg/refresh"
+	"github.com/qinchy/hellogo/pkg/scan"
+	"github.com/qinchy/hellogo/pkg/session"
+	"github.com/qinchy/hellogo/pkg/tus"
+	"github.com/qinchy/hellogo/pkg/upload"
 	"github.com/rifflock/lfshook"
 	"github.com/sirupsen/logrus"
+	"net/http"
 	"os"
+	"runtime/debug"
 	"time"
 )
 
@@ -34,6 +55,54 @@ var (
 
 	//Logger 全局Logger
 	Logger *logrus.Logger
+
+	// Users BasicAuth和登录接口使用的用户
+	Users *account.Store
+
+	// APIKeys 服务调用方使用的API key
+	APIKeys *apikey.Store
+
+	// Policy 角色和权限
+	Policy *rbac.Policy
+
+	// Audit 审计日志
+	Audit *audit.Log
+
+	// Tokens 签发和校验JWT访问令牌
+	Tokens *jwt.Manager
+
+	// RefreshTokens 刷新令牌
+	RefreshTokens *refresh.Store
+
+	// Sessions 会话
+	Sessions *session.Manager
+
+	// OIDC 页面登录使用的OpenID Connect认证服务器，没有配置时为nil
+	OIDC *oidc.Provider
+
+	// DeadLetters 解析或校验失败的记录
+	DeadLetters *deadletter.Store
+
+	// Uploads 上传文件存储
+	Uploads *upload.Store
+
+	// Progress 上传进度
+	Progress *progress.Tracker
+
+	// Outbound 访问外部服务共用的客户端，按主机熔断并重试
+	Outbound *outbound.Client
+
+	// Fetcher 按白名单访问上游的客户端
+	Fetcher *fetch.Fetcher
+
+	// FetchCache 上游资源的缓存，没有启用时为nil
+	FetchCache *fetch.Cache
+
+	// TusUploads 未完成的断点续传上传
+	TusUploads *tus.Store
+
+	// Quarantine 扫描出感染的上传文件
+	Quarantine *scan.Quarantine
 )
 
 // init 定制化gin的参数可以放到这里
@@ -43,11 +112,125 @@ func init() {
 
 	gin.SetMode(gin.ReleaseMode)
 
-	Route = gin.Default()
+	// 与gin.Default相同，但panic交给recoverPanic处理
+	Route = gin.New()
+	Route.Use(gin.Logger(), gin.CustomRecoveryWithWriter(nil, recoverPanic))
 	Logger = logrus.New()
 
 	Route.Use(loggerToFile())
 
+	var err error
+	if Users, err = account.Open(Conf.UserFile, Conf.PasswordCost); err != nil {
+		panic("系统初始化用户存储时出现错误：" + err.Error())
+	}
+	if Users.Len() == 0 {
+		Logger.Warnf("用户文件%s中没有用户，需要认证的接口都无法访问，可以使用 hellogo user add <name> 创建用户", Conf.UserFile)
+	}
+
+	if Policy, err = rbac.LoadPolicy(Conf.RBACPolicy); err != nil {
+		panic("系统初始化权限配置时出现错误：" + err.Error())
+	}
+	Audit = audit.New(Conf.AuditLog)
+	if APIKeys, err = apikey.Open(Conf.APIKeyFile); err != nil {
+		panic("系统初始化API key存储时出现错误：" + err.Error())
+	}
+
+	keys, err := jwt.LoadKeys(Conf.JWTKeys)
+	if errors.Is(err, os.ErrNotExist) {
+		Logger.Warnf("JWT密钥配置%s不存在，使用随机生成的密钥，重启后已签发的令牌全部失效", Conf.JWTKeys)
+		keys, err = jwt.EphemeralKeySet()
+	}
+	if err != nil {
+		panic("系统初始化JWT密钥时出现错误：" + err.Error())
+	}
+	Tokens = jwt.New(keys, jwt.Options{
+		Issuer:   Conf.JWTIssuer,
+		Audience: Conf.JWTAudience,
+		TTL:      Conf.JWTAccessTTL,
+		Leeway:   30 * time.Second,
+	})
+
+	if RefreshTokens, err = refresh.Open(Conf.RefreshTokenFile, Conf.RefreshTokenTTL); err != nil {
+		panic("系统初始化刷新令牌存储时出现错误：" + err.Error())
+	}
+
+	if Sessions, err = newSessions(); err != nil {
+		panic("系统初始化会话存储时出现错误：" + err.Error())
+	}
+
+	if _, err := compress.ParseOutputCodec(Conf.OutputCompression); err != nil {
+		panic("系统初始化输出配置时出现错误：" + err.Error())
+	}
+	DeadLetters = deadletter.New(Conf.DeadLetterFile)
+	Uploads, err = upload.NewStore(Conf.UploadRoot)
+	if err != nil {
+		panic("系统初始化上传存储时出现错误：" + err.Error())
+	}
+
+	// 上传文件在对外可见前先经过病毒扫描
+	Quarantine = scan.NewQuarantine(Conf.ScanQuarantineDir)
+	scanner, err := scan.New(Conf.Scanner, Conf.ClamdAddress, Conf.ScanTimeout)
+	if err != nil {
+		panic("系统初始化病毒扫描器时出现错误：" + err.Error())
+	}
+	switch s := scanner.(type) {
+	case nil:
+		Logger.Warn("没有启用病毒扫描，上传的文件不经扫描直接保存")
+	case scan.EICAR:
+		Logger.Warn("病毒扫描器为eicar，只能识别EICAR测试文件，不能用于生产环境")
+	case *scan.Clamd:
+		// clamd暂时不可用时只记录日志，上传在clamd恢复前会被拒绝
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := s.Ping(ctx); err != nil {
+			Logger.Errorf("无法连接clamd %s，上传将被拒绝：%s", Conf.ClamdAddress, err.Error())
+		}
+		cancel()
+	}
+	if scanner != nil {
+		Uploads.SetScanner(scan.Hook(scanner, Quarantine, Conf.ScanTimeout))
+	}
+	Progress = progress.NewTracker(Conf.UploadProgressRetention)
+	TusUploads = tus.NewStore(Conf.TusDir, Conf.TusMaxSize, Conf.TusExpiry)
+
+	Outbound = outbound.New(outbound.Options{
+		FailureThreshold: Conf.OutboundFailureThreshold,
+		OpenTimeout:      Conf.OutboundOpenTimeout,
+		MaxRetries:       Conf.OutboundMaxRetries,
+		RetryBackoff:     Conf.OutboundRetryBackoff,
+		OnStateChange: func(host string, from, to outbound.State) {
+			Logger.WithFields(logrus.Fields{
+				"host": host,
+				"from": from.String(),
+				"to":   to.String(),
+			}).Warn("上游熔断器状态变化")
+		},
+	}, nil)
+
+	oidcConfig, err := oidc.LoadConfig(Conf.OIDCConfig)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		Logger.Infof("OIDC配置%s不存在，页面只能通过登录接口建立的会话访问", Conf.OIDCConfig)
+	case err != nil:
+		panic("系统初始化OIDC配置时出现错误：" + err.Error())
+	default:
+		if OIDC, err = oidc.New(*oidcConfig, Outbound.HTTPClient()); err != nil {
+			panic("系统初始化OIDC配置时出现错误：" + err.Error())
+		}
+	}
+
+	fetchConfig, err := fetch.LoadConfig(Conf.FetchConfig)
+	if err != nil {
+		panic("系统初始化上游配置时出现错误：" + err.Error())
+	}
+	if Fetcher, err = fetch.New(fetchConfig, Outbound.HTTPClient()); err != nil {
+		panic("系统初始化上游配置时出现错误：" + err.Error())
+	}
+	if Conf.FetchCacheSize > 0 {
+		if FetchCache, err = fetch.NewCache(Fetcher, Conf.FetchCacheSize, Conf.FetchCacheDir, Conf.FetchCacheDiskSize); err != nil {
+			panic("系统初始化上游缓存时出现错误：" + err.Error())
+		}
+	}
+
 	// 注册校验器
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
 		// 这里的bookabledate就是校验器的名称，在结构体的required中使用
@@ -56,6 +239,56 @@ func init() {
 	}
 }
 
+// newSessions 按配置创建会话存储
+func newSessions() (*session.Manager, error) {
+	sameSite, err := session.ParseSameSite(Conf.SessionCookieSameSite)
+	if err != nil {
+		return nil, err
+	}
+	var store session.Store
+	switch Conf.SessionStore {
+	case "memory":
+		store = session.NewMemoryStore()
+	case "file":
+		if store, err = session.NewFileStore(Conf.SessionDir); err != nil {
+			return nil, err
+		}
+	case "cookie":
+		secret := []byte(Conf.SessionSecret)
+		if len(secret) == 0 {
+			Logger.Warn("没有配置HELLOGO_SESSION_SECRET，使用随机生成的会话密钥，重启后会话全部失效")
+			secret = make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return nil, err
+			}
+		}
+		if store, err = session.NewCookieStore(Conf.SessionCookieName, secret); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("unknown session store: " + Conf.SessionStore)
+	}
+	return session.New(store, session.Options{
+		CookieName:      Conf.SessionCookieName,
+		Domain:          Conf.SessionCookieDomain,
+		Secure:          Conf.SessionCookieSecure,
+		SameSite:        sameSite,
+		IdleTimeout:     Conf.SessionIdleTimeout,
+		AbsoluteTimeout: Conf.SessionAbsoluteTimeout,
+	}), nil
+}
+
+// recoverPanic 处理请求中的panic，记录日志后返回500。
+// http.ErrAbortHandler是处理器主动中断响应，继续抛给net/http，由它关闭连接或重置HTTP/2的流，
+// 否则响应会被当作正常结束，客户端拿到的是截断却看似完整的内容
+func recoverPanic(c *gin.Context, err interface{}) {
+	if err == http.ErrAbortHandler {
+		panic(err)
+	}
+	Logger.Errorf("处理请求%s时出现panic：%v\n%s", c.Request.URL.Path, err, debug.Stack())
+	c.AbortWithStatus(http.StatusInternalServerError)
+}
+
 // LoggerToFile 日志记录到文件
 func loggerToFile() gin.HandlerFunc {
 
@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/gin/types"
+	"github.com/qinchy/hellogo/pkg/account"
+	"github.com/qinchy/hellogo/pkg/apikey"
+	"github.com/qinchy/hellogo/pkg/audit"
+	"github.com/qinchy/hellogo/pkg/rbac"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiKeyKey 通过API key认证后key在gin.Context中的键
+const apiKeyKey = "hellogo/apikey"
+
+// APIKeyOr 请求带了API key（X-API-Key或者Authorization: Bearer hk_...）时校验API key，
+// 否则交给next认证。创建key的用户被禁用或删除、创建链上的key被撤销或过期后key同样失效。
+// 通过后用户名为"apikey:<id>"，权限由key的scopes决定
+// curl -k -H "X-API-Key: hk_..." "https://localhost/admin/deadletters"
+func APIKeyOr(next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw, ok := requestAPIKey(c)
+		if !ok {
+			next(c)
+			return
+		}
+		key, err := APIKeys.Authenticate(raw)
+		if err == nil {
+			_, err = keyCreator(key)
+		}
+		if err != nil {
+			Logger.WithFields(logrus.Fields{
+				"client": c.ClientIP(),
+				"path":   c.Request.URL.Path,
+			}).Warnf("API key无效：%s", err.Error())
+			c.Header("WWW-Authenticate", `Bearer realm="hellogo", error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+			return
+		}
+		c.Set(gin.AuthUserKey, "apikey:"+key.ID)
+		c.Set(apiKeyKey, key)
+	}
+}
+
+// errKeyCreatorInactive 创建API key的用户已经被禁用或删除，或者创建链上的key已经失效
+var errKeyCreatorInactive = errors.New("api key creator is disabled or deleted, or a parent key is revoked or expired")
+
+// keyCreator 沿创建链找到最初创建API key的用户。
+// 由API key创建的key依赖上级key，链上任何一个key被撤销、过期或者不存在时同样失效
+func keyCreator(key *apikey.Key) (*account.User, error) {
+	createdBy := key.CreatedBy
+	for depth := 0; depth < 16; depth++ {
+		id, ok := strings.CutPrefix(createdBy, "apikey:")
+		if !ok {
+			u, err := Users.Get(createdBy)
+			if err != nil || u.Disabled {
+				return nil, errKeyCreatorInactive
+			}
+			return u, nil
+		}
+		parent, err := APIKeys.Get(id)
+		if err != nil || !parent.Active() {
+			return nil, errKeyCreatorInactive
+		}
+		createdBy = parent.CreatedBy
+	}
+	return nil, errKeyCreatorInactive
+}
+
+// requestAPIKey 取出请求中的API key
+func requestAPIKey(c *gin.Context) (string, bool) {
+	if raw := c.GetHeader("X-API-Key"); raw != "" {
+		return raw, true
+	}
+	if token, ok := bearerToken(c); ok && apikey.IsKey(token) {
+		return token, true
+	}
+	return "", false
+}
+
+// requestKey 当前请求使用的API key，没有通过API key认证时为nil
+func requestKey(c *gin.Context) *apikey.Key {
+	if v, ok := c.Get(apiKeyKey); ok {
+		return v.(*apikey.Key)
+	}
+	return nil
+}
+
+// CreateAPIKey 生成API key，完整的key只在响应中出现一次。
+// scopes不能超出创建者自己的权限，没有指定expires_at时使用默认有效期。
+// 使用API key创建时有效期不会超过这个key本身
+// curl -k -u foo:bar -X POST "https://localhost/admin/apikeys" -H "Content-Type: application/json" -d '{"name":"batch","scopes":["deadletters:read","deadletters:redrive"]}'
+func CreateAPIKey(c *gin.Context) {
+	var form types.APIKeyForm
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, scope := range form.Scopes {
+		if !rbac.ValidPattern(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scope: " + scope})
+			return
+		}
+		if !callerAllowed(c, scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "scope exceeds your permissions", "scope": scope})
+			return
+		}
+	}
+	expiresAt := form.ExpiresAt
+	switch {
+	case expiresAt != nil && !expiresAt.After(time.Now()):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	case expiresAt == nil && Conf.APIKeyDefaultTTL > 0:
+		t := time.Now().Add(Conf.APIKeyDefaultTTL)
+		expiresAt = &t
+	}
+	if parent := requestKey(c); parent != nil && parent.ExpiresAt != nil &&
+		(expiresAt == nil || expiresAt.After(*parent.ExpiresAt)) {
+		t := *parent.ExpiresAt
+		expiresAt = &t
+	}
+
+	user := c.GetString(gin.AuthUserKey)
+	raw, key, err := APIKeys.Create(form.Name, form.Scopes, expiresAt, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	auditAPIKey(c, "apikey_created", key)
+	c.JSON(http.StatusCreated, gin.H{"key": raw, "api_key": key})
+}
+
+// ListAPIKeys 列出全部API key，不包含密钥
+func ListAPIKeys(c *gin.Context) {
+	keys := APIKeys.List()
+	c.JSON(http.StatusOK, gin.H{"total": len(keys), "items": keys})
+}
+
+// RevokeAPIKey 撤销API key，撤销后立即失效
+// curl -k -u foo:bar -X DELETE "https://localhost/admin/apikeys/hk_0123abcd"
+func RevokeAPIKey(c *gin.Context) {
+	key, err := APIKeys.Revoke(c.Param("id"))
+	if errors.Is(err, apikey.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	auditAPIKey(c, "apikey_revoked", key)
+	c.JSON(http.StatusOK, key)
+}
+
+// auditAPIKey 记录API key的创建和撤销
+func auditAPIKey(c *gin.Context, action string, key *apikey.Key) {
+	err := Audit.Record(audit.Entry{
+		User:    c.GetString(gin.AuthUserKey),
+		Action:  action,
+		Allowed: true,
+		Method:  c.Request.Method,
+		Path:    c.Request.URL.Path,
+		Client:  c.ClientIP(),
+		Detail:  key.ID + " " + key.Name + " scopes=" + strings.Join(key.Scopes, ","),
+	})
+	if err != nil {
+		Logger.Errorf("写入审计日志时出现异常：%s", err.Error())
+	}
+}
@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"archive/zip"
+	"context"
+	"github.com/gin-gonic/gin"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/upload"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxArchiveFiles 一个压缩包中文件数的上限
+const maxArchiveFiles = 1000
+
+// DownloadArchive 把多个文件边读边写打包成zip下载，不产生临时文件。
+// 通过ids（逗号分隔或重复多次）指定文件，或者通过prefix按文件名前缀选择
+// curl -k -u foo:bar -OJ "https://localhost/files/archive?ids=<id1>,<id2>"
+// curl -k -u foo:bar -OJ "https://localhost/files/archive?prefix=report&name=reports.zip"
+func DownloadArchive(c *gin.Context) {
+	files, ok := archiveFiles(c)
+	if !ok {
+		return
+	}
+
+	name := upload.SanitizeFilename(c.DefaultQuery("name", "files.zip"))
+	if !strings.EqualFold(filepath.Ext(name), ".zip") {
+		name += ".zip"
+	}
+	// 边打包边输出，无法提前知道长度
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", contentDisposition("attachment", name))
+	c.Header("Cache-Control", "private, no-store")
+	c.Status(http.StatusOK)
+
+	ctx := c.Request.Context()
+	zw := zip.NewWriter(c.Writer)
+	names := map[string]bool{}
+	var written int64
+	for i, f := range files {
+		if err := writeArchiveEntry(ctx, zw, f, uniqueName(names, f.Name)); err != nil {
+			// 响应头已经发出，只能中断输出；不写中央目录，客户端会得到一个不完整的压缩包而不是缺文件的压缩包
+			entry := Logger.WithFields(logrus.Fields{
+				"archive": name,
+				"file":    f.ID,
+				"done":    i,
+				"total":   len(files),
+				"bytes":   written,
+			})
+			if ctx.Err() != nil {
+				entry.Info("客户端取消了压缩包下载")
+			} else {
+				entry.Errorf("打包下载时出现异常：%s", err.Error())
+			}
+			c.Abort()
+			return
+		}
+		written += f.Size
+	}
+	if err := zw.Close(); err != nil {
+		Logger.WithFields(logrus.Fields{"archive": name}).Errorf("打包下载时出现异常：%s", err.Error())
+		return
+	}
+
+	Logger.WithFields(logrus.Fields{
+		"archive": name,
+		"count":   len(files),
+		"bytes":   written,
+		"user":    c.GetString(gin.AuthUserKey),
+	}).Info("打包下载完成")
+}
+
+// archiveFiles 按请求参数选出当前用户的文件，指定的文件有一个不存在就返回404
+func archiveFiles(c *gin.Context) ([]*upload.File, bool) {
+	user := c.GetString(gin.AuthUserKey)
+	var ids []string
+	for _, v := range c.QueryArray("ids") {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	prefix, hasPrefix := c.GetQuery("prefix")
+
+	var files []*upload.File
+	switch {
+	case len(ids) > 0 && hasPrefix:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids and prefix are mutually exclusive"})
+		return nil, false
+	case len(ids) > 0:
+		seen := map[string]bool{}
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			f, err := Uploads.Get(id)
+			if err != nil || !ownedBy(f, user) {
+				c.JSON(http.StatusNotFound, gin.H{"error": upload.ErrNotFound.Error(), "id": id})
+				return nil, false
+			}
+			files = append(files, f)
+		}
+	case hasPrefix && prefix != "":
+		for _, f := range Uploads.List() {
+			if ownedBy(f, user) && strings.HasPrefix(f.Name, prefix) {
+				files = append(files, f)
+			}
+		}
+		if len(files) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no files match the prefix"})
+			return nil, false
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids or prefix is required"})
+		return nil, false
+	}
+
+	if len(files) > maxArchiveFiles {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many files, at most " + strconv.Itoa(maxArchiveFiles)})
+		return nil, false
+	}
+	return files, true
+}
+
+// writeArchiveEntry 把一个文件写入压缩包，已经压缩过的格式只存储不再压缩
+func writeArchiveEntry(ctx context.Context, zw *zip.Writer, f *upload.File, name string) error {
+	content, err := Uploads.Open(f)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: f.CreatedAt,
+	}
+	if compressed(f.ContentType) {
+		header.Method = zip.Store
+	}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, contextReader{ctx: ctx, r: content})
+	return err
+}
+
+// compressed 内容本身已经压缩，再压缩没有收益
+func compressed(contentType string) bool {
+	switch {
+	case strings.HasPrefix(contentType, "image/") && contentType != "image/svg+xml" && contentType != "image/bmp":
+		return true
+	case strings.HasPrefix(contentType, "video/"), strings.HasPrefix(contentType, "audio/"):
+		return true
+	}
+	switch contentType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+		"application/x-bzip2", "application/x-7z-compressed", "application/x-rar-compressed", "application/pdf":
+		return true
+	}
+	return false
+}
+
+// uniqueName 压缩包中的重名文件依次改名为 a (1).txt、a (2).txt，比较时不区分大小写
+func uniqueName(names map[string]bool, name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; names[strings.ToLower(candidate)]; i++ {
+		candidate = base + " (" + strconv.Itoa(i) + ")" + ext
+	}
+	names[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// contextReader 请求被取消（客户端断开）后停止读取
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/gin/types"
+	"github.com/qinchy/hellogo/pkg/account"
+	"github.com/qinchy/hellogo/pkg/jwt"
+	"github.com/qinchy/hellogo/pkg/refresh"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+// ClaimsKey 通过JWT认证后令牌声明在gin.Context中的键
+const ClaimsKey = "hellogo/claims"
+
+// BasicAuth 使用用户文件中的账号校验BasicAuth，通过后把用户名设置到gin.AuthUserKey
+func BasicAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name, password, ok := c.Request.BasicAuth()
+		if !ok || !login(c, name, password) {
+			c.Header("WWW-Authenticate", `Basic realm="Authorization Required"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Set(gin.AuthUserKey, name)
+	}
+}
+
+// OptionalBasicAuth 带了BasicAuth凭证时校验并设置用户，没带时按匿名用户放行
+func OptionalBasicAuth() gin.HandlerFunc {
+	basicAuth := BasicAuth()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.Next()
+			return
+		}
+		basicAuth(c)
+	}
+}
+
+// JWTAuth 校验Authorization: Bearer中的访问令牌，通过后设置用户名和令牌声明。
+// 令牌签发后被禁用或删除的用户也会被拒绝
+// curl -k -H "Authorization: Bearer <access_token>" -X POST "https://localhost/v1/postformwithquery?id=11&page=1"
+func JWTAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c)
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer realm="hellogo"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "access token is required"})
+			return
+		}
+		claims, err := Tokens.Verify(token)
+		if err == nil {
+			var u *account.User
+			if u, err = Users.Get(claims.Subject); err == nil && u.Disabled {
+				err = account.ErrDisabled
+			}
+		}
+		if err != nil {
+			Logger.WithFields(logrus.Fields{
+				"client": c.ClientIP(),
+				"path":   c.Request.URL.Path,
+			}).Warnf("访问令牌无效：%s", err.Error())
+			c.Header("WWW-Authenticate", `Bearer realm="hellogo", error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid access token"})
+			return
+		}
+		c.Set(gin.AuthUserKey, claims.Subject)
+		c.Set(ClaimsKey, claims)
+	}
+}
+
+// TokenClaims 当前请求的令牌声明，没有通过JWT认证时为nil
+func TokenClaims(c *gin.Context) *jwt.Claims {
+	if v, ok := c.Get(ClaimsKey); ok {
+		return v.(*jwt.Claims)
+	}
+	return nil
+}
+
+// bearerToken 取出Authorization头中的Bearer令牌
+func bearerToken(c *gin.Context) (string, bool) {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// loggedIn 登录成功后签发访问令牌和新令牌族的刷新令牌
+func loggedIn(c *gin.Context, name string) {
+	refreshToken, _, err := RefreshTokens.Issue(name)
+	if err != nil {
+		Logger.Errorf("签发刷新令牌时出现异常：%s", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
+		return
+	}
+	// 登录后更换会话ID，防止登录前被植入的会话ID继续有效
+	if sess, err := Session(c); err == nil {
+		if err := sess.Regenerate(); err == nil {
+			sess.Set(sessionUserKey, name)
+		}
+	}
+	respondTokens(c, name, refreshToken, gin.H{"status": "you are logged in"})
+}
+
+// respondTokens 签发访问令牌，和刷新令牌一起返回
+func respondTokens(c *gin.Context, name, refreshToken string, body gin.H) {
+	token, claims, err := Tokens.Issue(name)
+	if err != nil {
+		Logger.Errorf("签发访问令牌时出现异常：%s", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
+		return
+	}
+	body["access_token"] = token
+	body["token_type"] = "Bearer"
+	body["expires_in"] = claims.ExpiresAt - claims.IssuedAt
+	body["refresh_token"] = refreshToken
+	body["refresh_expires_in"] = int64(RefreshTokens.TTL().Seconds())
+	c.JSON(http.StatusOK, body)
+}
+
+// RefreshToken 用刷新令牌换取新的访问令牌和刷新令牌，旧的刷新令牌随即失效。
+// 已经用过的刷新令牌再次出现时撤销整个令牌族，该次登录签发的刷新令牌全部失效
+// curl -k -X POST "https://localhost/auth/refresh" -H "Content-Type: application/json" -d '{"refresh_token":"<refresh_token>"}'
+func RefreshToken(c *gin.Context) {
+	var form types.RefreshForm
+	if err := c.ShouldBind(&form); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	refreshToken, token, err := RefreshTokens.Rotate(form.RefreshToken)
+	if err != nil {
+		entry := Logger.WithFields(logrus.Fields{"client": c.ClientIP()})
+		if errors.Is(err, refresh.ErrReused) {
+			entry.WithFields(logrus.Fields{
+				"user":   token.User,
+				"family": token.Family,
+			}).Warn("刷新令牌被重复使用，已撤销整个令牌族")
+		} else {
+			entry.Warnf("刷新令牌无效：%s", err.Error())
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		return
+	}
+
+	// 用户在刷新令牌有效期内被禁用或删除
+	if u, err := Users.Get(token.User); err != nil || u.Disabled {
+		if err := RefreshTokens.RevokeFamily(token.Family); err != nil {
+			Logger.Errorf("撤销刷新令牌时出现异常：%s", err.Error())
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		return
+	}
+	respondTokens(c, token.User, refreshToken, gin.H{})
+}
+
+// Logout 撤销刷新令牌所在的令牌族并销毁会话，已签发的访问令牌在过期前仍然有效。
+// 令牌无效时同样返回成功，避免借此探测令牌
+// curl -k -X POST "https://localhost/auth/logout" -H "Content-Type: application/json" -d '{"refresh_token":"<refresh_token>"}'
+func Logout(c *gin.Context) {
+	var form types.RefreshForm
+	if err := c.ShouldBind(&form); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := RefreshTokens.Revoke(form.RefreshToken)
+	if err != nil && !errors.Is(err, refresh.ErrInvalid) {
+		Logger.Errorf("撤销刷新令牌时出现异常：%s", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke token"})
+		return
+	}
+	if err == nil {
+		Logger.WithFields(logrus.Fields{"user": user}).Info("用户退出登录")
+	}
+	if sess, err := Session(c); err == nil {
+		sess.Destroy()
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "you are logged out"})
+}
+
+// CleanExpiredRefreshTokens 删除已过期的刷新令牌
+func CleanExpiredRefreshTokens() {
+	removed, err := RefreshTokens.Cleanup()
+	if err != nil {
+		Logger.Errorf("清理刷新令牌时出现异常：%s", err.Error())
+		return
+	}
+	if removed > 0 {
+		Logger.WithFields(logrus.Fields{
+			"removed": removed,
+		}).Info("已清理过期的刷新令牌")
+	}
+}
+
+// ReloadUsers 重新加载被命令行修改过的用户文件
+func ReloadUsers() {
+	if err := Users.Reload(); err != nil {
+		Logger.Errorf("重新加载用户文件时出现异常：%s", err.Error())
+	}
+}
+
+// login 校验用户名和密码，失败时记录日志
+func login(c *gin.Context, name, password string) bool {
+	_, err := Users.Authenticate(name, password)
+	if err == nil {
+		return true
+	}
+	entry := Logger.WithFields(logrus.Fields{
+		"user":   name,
+		"client": c.ClientIP(),
+		"path":   c.Request.URL.Path,
+	})
+	if errors.Is(err, account.ErrDisabled) {
+		entry.Warn("已禁用的用户尝试登录")
+	} else {
+		entry.Warn("用户名或密码错误")
+	}
+	return false
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -99,6 +100,7 @@ func SomeProtoBuf(c *gin.Context) {
 func Index(c *gin.Context) {
 	c.HTML(http.StatusOK, "index.tmpl", gin.H{
 		"title": "Main website",
+		"user":  c.GetString(gin.AuthUserKey),
 	})
 }
 
@@ -106,6 +108,7 @@ func Index(c *gin.Context) {
 func PostIndex(c *gin.Context) {
 	c.HTML(http.StatusOK, "posts/index.tmpl", gin.H{
 		"title": "Posts",
+		"user":  c.GetString(gin.AuthUserKey),
 	})
 }
 
@@ -113,6 +116,7 @@ func PostIndex(c *gin.Context) {
 func UsersIndex(c *gin.Context) {
 	c.HTML(http.StatusOK, "users/index.tmpl", gin.H{
 		"title": "Users",
+		"user":  c.GetString(gin.AuthUserKey),
 	})
 }
 
@@ -150,8 +154,8 @@ func LoginForm(c *gin.Context) {
 	var form types.LoginForm
 	// 在这种情况下，将自动选择合适的绑定
 	if c.ShouldBind(&form) == nil {
-		if form.User == "user" && form.Password == "password" {
-			c.JSON(200, gin.H{"status": "you are logged in"})
+		if login(c, form.User, form.Password) {
+			loggedIn(c, form.User)
 		} else {
 			c.JSON(401, gin.H{"status": "unauthorized"})
 		}
@@ -185,12 +189,12 @@ func LoginJson(c *gin.Context) {
 		return
 	}
 
-	if json.User != "user" || json.Password != "password" {
+	if !login(c, json.User, json.Password) {
 		c.JSON(http.StatusUnauthorized, gin.H{"status": "unauthorized"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "you are logged in"})
+	loggedIn(c, json.User)
 }
 
 // LoginXml xml绑定到结构体
@@ -201,12 +205,12 @@ func LoginXml(c *gin.Context) {
 		return
 	}
 
-	if xml.User != "user" || xml.Password != "password" {
+	if !login(c, xml.User, xml.Password) {
 		c.JSON(http.StatusUnauthorized, gin.H{"status": "unauthorized"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "you are logged in"})
+	loggedIn(c, xml.User)
 }
 
 // PostForm 从表单中获取数据
@@ -269,59 +273,6 @@ func PureJson(c *gin.Context) {
 	})
 }
 
-// SingleUpload 通过表单上传单个文件
-func SingleUpload(c *gin.Context) {
-	// 单文件
-	file, _ := c.FormFile("file")
-
-	// 这里的当前目录是整个工程的路径，比如当前是$GOPATH/src/github.com/qinchy/hellogo
-	// 感觉这个目录是go.mod中module的路径
-	dst := "./" + file.Filename
-	// 上传文件至指定的完整文件路径
-	err := c.SaveUploadedFile(file, dst)
-	if err != nil {
-		Logger.Fatalf("上传文件时出现异常：%s", err.Error())
-	}
-
-	c.String(http.StatusOK, fmt.Sprintf("'%s' uploaded!", file.Filename))
-}
-
-// MultiUpload 通过表单上传多个文件
-func MultiUpload(c *gin.Context) {
-	// Multipart form
-	form, _ := c.MultipartForm()
-	files := form.File["upload[]"]
-
-	for _, file := range files {
-		dst := "./" + file.Filename + ".bak"
-		// 上传文件至指定目录
-		err := c.SaveUploadedFile(file, dst)
-		if err != nil {
-			Logger.Fatalf("上传文件时出现异常：%s", err.Error())
-		}
-	}
-	c.String(http.StatusOK, fmt.Sprintf("%d files uploaded!", len(files)))
-}
-
-// FetchFromReader 从reader中获取数据
-func FetchFromReader(c *gin.Context) {
-	response, err := http.Get("https://www.baidu.com/img/PCtm_d9c8750bed0b3c7d089fa7d55720d6cf.png")
-	if err != nil || response.StatusCode != http.StatusOK {
-		c.Status(http.StatusServiceUnavailable)
-		return
-	}
-
-	reader := response.Body
-	contentLength := response.ContentLength
-	contentType := response.Header.Get("Content-Type")
-
-	extraHeaders := map[string]string{
-		"Content-Disposition": `attachment; filename="gopher.png"`,
-	}
-
-	c.DataFromReader(http.StatusOK, contentLength, contentType, reader, extraHeaders)
-}
-
 // LongAsync 在处理器中使用协程，需要使用上下文的副本
 func LongAsync(c *gin.Context) {
 	// 创建在 goroutine 中使用的副本
@@ -368,15 +319,21 @@ func GetBookable(c *gin.Context) {
 	}
 }
 
-// Cookie cookie演示
+// Cookie 会话演示，记录访问次数和登录用户，cookie的域名、Secure和SameSite由配置决定
+// curl -k -c cookies.txt -b cookies.txt "https://localhost/cookie"
 func Cookie(c *gin.Context) {
-
-	cookie, err := c.Cookie("gin_cookie")
-
+	sess, err := Session(c)
 	if err != nil {
-		cookie = "NotSet"
-		c.SetCookie("gin_cookie", "test", 3600, "/", "localhost", false, true)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
-
-	c.JSON(http.StatusOK, gin.H{"Cookie": cookie})
+	visits, _ := strconv.Atoi(sess.Get("visits"))
+	visits++
+	sess.Set("visits", strconv.Itoa(visits))
+
+	c.JSON(http.StatusOK, gin.H{
+		"visits": visits,
+		"user":   sess.Get(sessionUserKey),
+		"since":  sess.CreatedAt,
+	})
 }
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// ListBreakers 列出访问过的上游主机及其熔断器状态
+func ListBreakers(c *gin.Context) {
+	states := Outbound.Breakers()
+	c.JSON(http.StatusOK, gin.H{"total": len(states), "items": states})
+}
+
+// ResetBreaker 手动关闭主机的熔断器，上游恢复后不必等待探测
+func ResetBreaker(c *gin.Context) {
+	host := c.Param("host")
+	if !Outbound.ResetBreaker(host) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "breaker not found"})
+		return
+	}
+	Logger.WithFields(logrus.Fields{
+		"host":     host,
+		"operator": c.GetString(gin.AuthUserKey),
+	}).Info("手动关闭上游熔断器")
+	c.JSON(http.StatusOK, gin.H{"host": host, "state": "closed"})
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/compress"
+	"github.com/qinchy/hellogo/pkg/convert"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// LimitBody 限制请求体大小，超过时读取请求体返回错误
+func LimitBody(max int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if max > 0 {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, max)
+		}
+		c.Next()
+	}
+}
+
+// Convert 流式转换请求体的数据格式，复用CSV、JSON、YAML、XML和proto.Test的编解码
+func Convert(c *gin.Context) {
+	from, err := convert.ParseFormat(c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to, err := convert.ParseFormat(c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	schema, err := convert.ParseSchema(c.Query("schema"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 请求体可以是压缩过的，解压后的大小同样受限，避免压缩炸弹
+	zr, _, err := compress.NewReader(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer zr.Close()
+	body := zr
+	if Conf.ConvertMaxBodySize > 0 {
+		body = http.MaxBytesReader(c.Writer, zr, Conf.ConvertMaxBodySize)
+	}
+
+	c.Header("Content-Type", to.ContentType())
+	c.Status(http.StatusOK)
+	n, err := convert.Convert(from, to, body, c.Writer, schema)
+	if err == nil {
+		return
+	}
+
+	Logger.WithFields(logrus.Fields{
+		"from":    from,
+		"to":      to,
+		"records": n,
+	}).Warnf("格式转换失败：%s", err.Error())
+
+	// 还没有输出任何内容时可以返回错误，否则只能中断响应
+	if !c.Writer.Written() {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		c.Writer.Header().Del("Content-Type")
+		c.JSON(status, gin.H{"error": err.Error(), "records": n})
+		return
+	}
+	c.Abort()
+}
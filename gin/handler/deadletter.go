@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/deadletter"
+	"github.com/qinchy/hellogo/pkg/read"
+	"net/http"
+)
+
+// ListDeadLetters 列出所有死信
+func ListDeadLetters(c *gin.Context) {
+	entries, err := DeadLetters.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"total": len(entries), "items": entries})
+}
+
+// RedriveDeadLetter 重新处理单条死信，通过的记录写出到输出目录
+func RedriveDeadLetter(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := DeadLetters.Get(id); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, deadletter.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := read.Redrive([]string{id}, read.LogRecord)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"id": id, "error": err.Error()})
+		return
+	}
+	if reason, ok := result.Failed[id]; ok {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"id": id, "error": reason})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "status": "redriven", "output": result.Output})
+}
+
+// RedriveDeadLetters 重新处理全部死信，通过的记录写出到同一个输出文件
+func RedriveDeadLetters(c *gin.Context) {
+	entries, err := DeadLetters.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	result, err := read.Redrive(ids, read.LogRecord)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"redriven": len(result.Redriven), "failed": result.Failed, "output": result.Output})
+}
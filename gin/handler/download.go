@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Download 一个可下载的内容
+type Download struct {
+	Content     io.ReadSeeker
+	Name        string
+	ContentType string
+	// Hash 内容的哈希，用作强ETag
+	Hash    string
+	ModTime time.Time
+	// Inline 为true时浏览器直接展示，否则作为附件下载
+	Inline bool
+}
+
+// ServeDownload 输出下载内容，支持Range和多段Range（206）、If-Range，
+// If-None-Match和If-Modified-Since（304）以及If-Match和If-Unmodified-Since（412）
+// 与DataFromReader不同，这里需要可Seek的内容，具体的协议处理交给http.ServeContent
+func ServeDownload(c *gin.Context, d Download) {
+	header := c.Writer.Header()
+	// 设置了Content-Type后ServeContent不会再去嗅探内容
+	if d.ContentType == "" {
+		d.ContentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", d.ContentType)
+	if d.Hash != "" {
+		header.Set("ETag", `"`+d.Hash+`"`)
+	}
+	disposition := "attachment"
+	if d.Inline {
+		disposition = "inline"
+	}
+	header.Set("Content-Disposition", contentDisposition(disposition, d.Name))
+	// 内容按哈希寻址不会改变，但属于用户私有数据，只允许浏览器缓存并且每次验证
+	header.Set("Cache-Control", "private, no-cache")
+
+	http.ServeContent(c.Writer, c.Request, d.Name, d.ModTime, d.Content)
+}
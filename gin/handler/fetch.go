@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/fetch"
+	"github.com/qinchy/hellogo/pkg/outbound"
+	"github.com/qinchy/hellogo/pkg/upload"
+	"github.com/sirupsen/logrus"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+)
+
+// FetchFromReader 代理获取白名单中的上游资源，响应边读边写给客户端。
+// 启用缓存时按上游的缓存策略优先返回缓存，X-Cache响应头表示命中情况。
+// 不带url参数时获取配置中的默认地址
+// curl -k -OJ "https://localhost/fetchfromreader?url=https://www.baidu.com/img/PCtm_d9c8750bed0b3c7d089fa7d55720d6cf.png"
+func FetchFromReader(c *gin.Context) {
+	target := c.DefaultQuery("url", Fetcher.DefaultURL())
+	if target == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+		return
+	}
+
+	var resp *fetch.Response
+	var err error
+	if FetchCache != nil {
+		resp, err = FetchCache.Fetch(c.Request.Context(), target, c.Request.Header)
+	} else {
+		resp, err = Fetcher.Fetch(c.Request.Context(), target, c.Request.Header)
+	}
+	if err != nil {
+		fetchError(c, target, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.Cache != "" {
+		c.Header("X-Cache", resp.Cache)
+	}
+
+	extraHeaders := map[string]string{}
+	for name := range resp.Header {
+		extraHeaders[name] = resp.Header.Get(name)
+	}
+	extraHeaders["Content-Disposition"] = contentDisposition("attachment", fetchFilename(target))
+
+	c.DataFromReader(resp.Status, resp.ContentLength, resp.Header.Get("Content-Type"), resp.Body, extraHeaders)
+	if err := c.Errors.Last(); err != nil {
+		// 响应头已经发出，只能记录日志
+		Logger.WithFields(logrus.Fields{
+			"url":      target,
+			"upstream": resp.Upstream.Name,
+		}).Warnf("转发上游响应时中断：%s", err.Error())
+		if errors.Is(err, fetch.ErrTooLarge) {
+			// 长度未知的响应超过限制时断开连接，让客户端知道内容不完整
+			abortConnection(c)
+		}
+	}
+}
+
+// fetchFilename 下载的文件名取地址的最后一段
+func fetchFilename(target string) string {
+	if u, err := url.Parse(target); err == nil {
+		if name := path.Base(u.Path); name != "." && name != "/" {
+			return upload.SanitizeFilename(name)
+		}
+	}
+	return "download"
+}
+
+// fetchError 把访问上游的错误转换为对应状态码的JSON响应
+func fetchError(c *gin.Context, target string, err error) {
+	var statusErr *fetch.StatusError
+	var openErr *outbound.OpenError
+	status := http.StatusServiceUnavailable
+	switch {
+	case errors.Is(err, fetch.ErrNotAllowed):
+		status = http.StatusForbidden
+	case errors.As(err, &openErr):
+		// 熔断期间不访问上游，告诉客户端多久后再试
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(openErr.RetryAfter.Seconds()))))
+	case errors.Is(err, fetch.ErrTooLarge):
+		status = http.StatusBadGateway
+	case errors.As(err, &statusErr):
+		// 上游的4xx原样返回，5xx按网关错误处理
+		status = statusErr.Status
+		if status >= http.StatusInternalServerError {
+			status = http.StatusBadGateway
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	}
+
+	Logger.WithFields(logrus.Fields{
+		"url":    target,
+		"status": status,
+	}).Warnf("访问上游失败：%s", err.Error())
+	c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+}
+
+// abortConnection 中断响应，让客户端知道内容不完整。
+// HTTP/1.x直接关闭连接，客户端会因为分块编码没有正常结束而报错；
+// HTTP/2的连接由多个流共用不能劫持，通过http.ErrAbortHandler让net/http重置这个流
+func abortConnection(c *gin.Context) {
+	if c.Request.ProtoMajor == 1 {
+		if conn, _, err := c.Writer.Hijack(); err == nil {
+			conn.Close()
+			return
+		}
+	}
+	panic(http.ErrAbortHandler)
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"errors"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/fetch"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+// TestMain 只注册测试用到的路由，Handler依赖工作目录下的模板。
+// Route上已有的中间件包括panic的处理
+func TestMain(m *testing.M) {
+	Route.GET("/fetchfromreader", FetchFromReader)
+	os.Exit(m.Run())
+}
+
+// useTestFetcher 让FetchFromReader只访问测试上游，不经过缓存
+func useTestFetcher(t *testing.T, upstream *httptest.Server, maxSize int64) {
+	t.Helper()
+	f, err := fetch.New(fetch.Config{Upstreams: []fetch.Upstream{{
+		Name:    "test",
+		BaseURL: upstream.URL + "/img/",
+		MaxSize: maxSize,
+	}}}, upstream.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetcher, cache := Fetcher, FetchCache
+	Fetcher, FetchCache = f, nil
+	t.Cleanup(func() { Fetcher, FetchCache = fetcher, cache })
+}
+
+func TestFetchTooLargeAbortsResponse(t *testing.T) {
+	const limit = 64 << 10
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 不声明长度，转发到一半才发现超过限制，此时响应头和部分内容已经发给客户端
+		for i := 0; i < 4; i++ {
+			w.Write(make([]byte, 32<<10))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer upstream.Close()
+	useTestFetcher(t, upstream, limit)
+
+	for _, proto := range []string{"HTTP/1.1", "HTTP/2.0"} {
+		t.Run(proto, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(Route)
+			srv.EnableHTTP2 = proto == "HTTP/2.0"
+			srv.StartTLS()
+			defer srv.Close()
+
+			resp, err := srv.Client().Get(srv.URL + "/fetchfromreader?url=" + url.QueryEscape(upstream.URL+"/img/big"))
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.Proto != proto {
+				t.Fatalf("proto = %s, want %s", resp.Proto, proto)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d", resp.StatusCode)
+			}
+
+			// 客户端必须读到错误，而不是一个看似完整的截断响应
+			data, err := io.ReadAll(resp.Body)
+			if err == nil {
+				t.Fatalf("read %d bytes without error, want the response to be aborted", len(data))
+			}
+			if errors.Is(err, io.EOF) {
+				t.Fatalf("read err = %v, want an abort", err)
+			}
+			if len(data) > limit {
+				t.Fatalf("read %d bytes, more than the limit %d", len(data), limit)
+			}
+		})
+	}
+}
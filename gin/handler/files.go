@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/upload"
+	"github.com/sirupsen/logrus"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPageSize 列表接口每页的最大条数
+const maxPageSize = 100
+
+// ListFiles 分页列出当前用户上传的文件，支持按文件名、类型和上传时间过滤
+// curl -k -u foo:bar "https://localhost/files?page=1&page_size=20&name=report&content_type=image/&since=2023-04-01T00:00:00Z"
+func ListFiles(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page"})
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if err != nil || pageSize < 1 || pageSize > maxPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page_size must be between 1 and " + strconv.Itoa(maxPageSize)})
+		return
+	}
+	var since time.Time
+	if s := c.Query("since"); s != "" {
+		if since, err = time.Parse(time.RFC3339, s); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be RFC3339"})
+			return
+		}
+	}
+	name := strings.ToLower(c.Query("name"))
+	contentType := c.Query("content_type")
+
+	user := c.GetString(gin.AuthUserKey)
+	items := make([]*upload.File, 0)
+	for _, f := range Uploads.List() {
+		switch {
+		case !ownedBy(f, user):
+		case name != "" && !strings.Contains(strings.ToLower(f.Name), name):
+		case contentType != "" && !strings.HasPrefix(f.ContentType, contentType):
+		case !since.IsZero() && f.CreatedAt.Before(since):
+		default:
+			items = append(items, f)
+		}
+	}
+
+	total := len(items)
+	start := (page - 1) * pageSize
+	if start > total {
+		start = total
+	}
+	end := start + pageSize
+	if end > total {
+		end = total
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+		"items":     items[start:end],
+	})
+}
+
+// GetFile 查询文件元数据
+func GetFile(c *gin.Context) {
+	f, ok := ownedFile(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, f)
+}
+
+// DownloadFile 下载文件内容，支持断点续传和条件请求
+// curl -k -u foo:bar -r 0-99 "https://localhost/files/<id>/download"
+func DownloadFile(c *gin.Context) {
+	f, ok := ownedFile(c)
+	if !ok {
+		return
+	}
+	content, err := Uploads.Open(f)
+	if err != nil {
+		Logger.WithFields(logrus.Fields{"id": f.ID}).Errorf("打开文件时出现异常：%s", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer content.Close()
+
+	ServeDownload(c, Download{
+		Content:     content,
+		Name:        f.Name,
+		ContentType: f.ContentType,
+		Hash:        f.Hash,
+		ModTime:     f.CreatedAt,
+	})
+}
+
+// DeleteFile 删除文件
+func DeleteFile(c *gin.Context) {
+	f, ok := ownedFile(c)
+	if !ok {
+		return
+	}
+	if err := Uploads.Delete(f.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	Logger.WithFields(logrus.Fields{
+		"id":   f.ID,
+		"file": f.Name,
+		"user": f.Uploader,
+	}).Info("文件已删除")
+	c.Status(http.StatusNoContent)
+}
+
+// ownedFile 取出路径中的文件，文件不存在或不属于当前用户时返回404
+func ownedFile(c *gin.Context) (*upload.File, bool) {
+	f, err := Uploads.Get(c.Param("id"))
+	if err == nil && !ownedBy(f, c.GetString(gin.AuthUserKey)) {
+		// 不暴露其他用户文件是否存在
+		err = upload.ErrNotFound
+	}
+	if errors.Is(err, upload.ErrNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return f, true
+}
+
+// contentDisposition 生成Content-Disposition，非ASCII文件名按RFC 2231编码
+func contentDisposition(disposition, filename string) string {
+	if v := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); v != "" {
+		return v
+	}
+	return disposition
+}
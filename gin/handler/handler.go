@@ -3,11 +3,15 @@ package handler
 import (
 	"github.com/gin-gonic/gin"
 	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/upload"
 	"net/http"
 )
 
 // Handler 所有handler的集合都放这里
 func Handler() {
+	// 会话在处理器第一次调用Session时才加载
+	Route.Use(WithSession())
+
 	Route.GET("/ping", Ping)
 
 	Route.GET("/somejson", SomeJson)
@@ -22,11 +26,16 @@ func Handler() {
 
 	Route.LoadHTMLGlob("templates/**/*")
 
-	Route.GET("/index", Index)
+	// 页面需要登录，没有会话时跳转到OIDC登录，登录后回到原来的页面
+	Route.GET("/index", RequireLogin(), Index)
+
+	Route.GET("/posts/index", RequireLogin(), PostIndex)
 
-	Route.GET("/posts/index", PostIndex)
+	Route.GET("/users/index", RequireLogin(), UsersIndex)
 
-	Route.GET("/users/index", UsersIndex)
+	// OpenID Connect登录，使用授权码模式加PKCE，回调地址需要和oidc.yaml中的redirect_url一致
+	Route.GET("/auth/oidc/login", OIDCLogin)
+	Route.GET("/auth/oidc/callback", OIDCCallback)
 
 	Route.GET("/jsonp", JsonP)
 
@@ -45,6 +54,7 @@ func Handler() {
 	Route.GET("/getd", GetDataD)
 
 	// 绑定 JSON ({"user": "user", "password": "password"})
+	// 登录成功后返回访问令牌access_token，访问/v1、/v2时放在Authorization: Bearer中
 	Route.POST("/loginjson", LoginJson)
 
 	// 绑定 XML (
@@ -57,6 +67,10 @@ func Handler() {
 	// </root>'
 	Route.POST("/loginxml", LoginXml)
 
+	// 刷新令牌轮换和退出登录
+	Route.POST("/auth/refresh", RefreshToken)
+	Route.POST("/auth/logout", Logout)
+
 	Route.POST("/postform", PostForm)
 
 	// 提供 unicode 实体
@@ -77,28 +91,97 @@ func Handler() {
 	// 为 multipart forms 设置较低的内存限制 (默认是 32 MiB)
 	// curl -k -X POST https://localhost/singleupload  -F "file=@D:\Source_Code\go\src\github.com\qinchy\hellogo\cmd\main.go"   -H "Content-Type: multipart/form-data"
 	Route.MaxMultipartMemory = 8 << 20 // 8 MiB
-	Route.POST("/singleupload", SingleUpload)
+	// 带BasicAuth凭证上传时记录上传者，之后可以通过/files管理
+	// 文件类型按内容嗅探，默认拒绝可执行文件以及内容与扩展名不一致的文件
+	Route.POST("/singleupload", OptionalBasicAuth(), TrackProgress, LimitUpload(upload.Limits{MaxFileSize: Conf.UploadMaxFileSize, MaxFiles: 1}), UploadPolicy(DefaultUploadPolicy()), SingleUpload)
 
 	// curl -k -X POST https://localhost/multiupload  -F "upload[]=@C:\Users\Administrator\AppData\Local\Temp\GoLand\___go_build_github_com_qinchy_hellogo_cmd.exe"   -F "upload[]=@D:\Source_Code\go\bin\hellogo\go_build_github_com_qinchy_hellogo.exe"   -H "Content-Type: multipart/form-data"
-	Route.POST("/multiupload", MultiUpload)
+	Route.POST("/multiupload", OptionalBasicAuth(), TrackProgress, LimitUpload(DefaultUploadLimits()), UploadPolicy(DefaultUploadPolicy()), MultiUpload)
+
+	// 流式上传，文件不经过临时文件直接写入存储，适合大文件
+	// curl -k -X POST https://localhost/streamupload -F "file=@big.iso" -F "file=@notes.txt"
+	Route.POST("/streamupload", OptionalBasicAuth(), TrackProgress, LimitUpload(DefaultUploadLimits()), UploadPolicy(DefaultUploadPolicy()), StreamUpload)
+
+	// 上传进度，上传请求带上X-Upload-ID请求头或upload_id参数后，上传者可以用同样的凭证订阅
+	// curl -k -u foo:bar -X POST "https://localhost/streamupload" -H "X-Upload-ID: <id>" -F "file=@big.iso"
+	// curl -k -N -u foo:bar "https://localhost/uploads/<id>/progress"
+	Route.GET("/uploads/:id/progress", OptionalBasicAuth(), UploadProgress)
+
+	// 断点续传上传，实现tus 1.0协议的creation、termination和expiration扩展
+	// curl -k -X POST "https://localhost/tus/" -H "Tus-Resumable: 1.0.0" -H "Upload-Length: 11" -H "Upload-Metadata: filename aGVsbG8udHh0"
+	// curl -k -X PATCH "https://localhost/tus/<id>" -H "Tus-Resumable: 1.0.0" -H "Upload-Offset: 0" -H "Content-Type: application/offset+octet-stream" --data-binary "hello world"
+	tusGroup := Route.Group("/tus", TusResumable, OptionalBasicAuth())
+	{
+		tusGroup.OPTIONS("/", TusOptions)
+		tusGroup.POST("/", TusCreate)
+		tusGroup.HEAD("/:id", TusHead)
+		tusGroup.PATCH("/:id", TusPatch)
+		tusGroup.DELETE("/:id", TusDelete)
+	}
+
+	// 上传文件管理，只能访问自己上传的文件
+	// curl -k -u foo:bar "https://localhost/files?page=1&page_size=20"
+	// curl -k -u foo:bar -OJ "https://localhost/files/<id>/download"
+	files := Route.Group("/files", BasicAuth())
+	{
+		files.GET("", ListFiles)
+		// 打包下载多个文件
+		// curl -k -u foo:bar -OJ "https://localhost/files/archive?ids=<id1>,<id2>"
+		files.GET("/archive", DownloadArchive)
+		files.GET("/:id", GetFile)
+		files.GET("/:id/download", DownloadFile)
+		files.HEAD("/:id/download", DownloadFile)
+		// curl -k -u foo:bar -o thumb.jpg "https://localhost/files/<id>/thumb?size=256"
+		files.GET("/:id/thumb", FileThumb)
+		files.DELETE("/:id", DeleteFile)
+	}
 
+	// 代理获取白名单中的上游资源，白名单在HELLOGO_FETCH_CONFIG指定的文件中配置
+	// curl -k -OJ "https://localhost/fetchfromreader?url=https://www.baidu.com/img/PCtm_d9c8750bed0b3c7d089fa7d55720d6cf.png"
 	Route.GET("/fetchfromreader", FetchFromReader)
 
+	// 格式转换，支持csv、json、jsonl、yaml、xml、protobuf，需要登录，请求体大小受HELLOGO_CONVERT_MAX_BODY_SIZE限制
+	// curl -k -u foo:bar -X POST "https://localhost/convert?from=csv&to=json&schema=id:int,name:string" --data-binary @data.csv
+	Route.POST("/convert", BasicAuth(), LimitBody(Conf.ConvertMaxBodySize), Convert)
+
 	//  =================使用 BasicAuth 中间件==================
-	// 路由组使用 gin.BasicAuth() 中间件
-	// gin.Accounts 是 map[string]string 的一种快捷方式
+	// 路由组使用 BasicAuth() 中间件，账号保存在用户文件中，使用 hellogo user 命令管理
+	// 每个接口还需要用户的角色拥有对应的权限，hellogo user roles foo admin
 	// authorized是一个路由组
-	authorized := Route.Group("/admin", gin.BasicAuth(gin.Accounts{
-		"foo":    "bar",
-		"austin": "1234",
-		"lena":   "hello2",
-		"manu":   "4321",
-	}))
+	// 服务调用方可以使用API key代替BasicAuth，权限由key的scopes决定
+	authorized := Route.Group("/admin", APIKeyOr(BasicAuth()))
 
 	// /admin/secrets 端点
 	// 触发 "localhost:443/admin/secrets
 	// 路由组下面的子路由
-	authorized.GET("/secrets", Getting)
+	authorized.GET("/secrets", RequirePermission(PermSecretsRead), Getting)
+
+	// 死信管理
+	// curl -k -u foo:bar "https://localhost/admin/deadletters"
+	authorized.GET("/deadletters", RequirePermission(PermDeadLettersRead), ListDeadLetters)
+	// curl -k -u foo:bar -X POST "https://localhost/admin/deadletters/redrive"
+	authorized.POST("/deadletters/redrive", RequirePermission(PermDeadLettersRedrive), RedriveDeadLetters)
+	authorized.POST("/deadletters/:id/redrive", RequirePermission(PermDeadLettersRedrive), RedriveDeadLetter)
+
+	// 隔离区复核
+	// curl -k -u foo:bar "https://localhost/admin/quarantine"
+	// curl -k -u foo:bar -X POST "https://localhost/admin/quarantine/<id>/release"
+	authorized.GET("/quarantine", RequirePermission(PermQuarantineRead), ListQuarantine)
+	authorized.GET("/quarantine/:id/download", RequirePermission(PermQuarantineDownload), DownloadQuarantined)
+	authorized.POST("/quarantine/:id/release", RequirePermission(PermQuarantineReview), ReleaseQuarantined)
+	authorized.DELETE("/quarantine/:id", RequirePermission(PermQuarantineReview), DeleteQuarantined)
+
+	// 上游熔断器
+	// curl -k -u foo:bar "https://localhost/admin/breakers"
+	// curl -k -u foo:bar -X POST "https://localhost/admin/breakers/www.baidu.com/reset"
+	authorized.GET("/breakers", RequirePermission(PermBreakersRead), ListBreakers)
+	authorized.POST("/breakers/:host/reset", RequirePermission(PermBreakersReset), ResetBreaker)
+
+	// API key管理
+	// curl -k -u foo:bar "https://localhost/admin/apikeys"
+	authorized.GET("/apikeys", RequirePermission(PermAPIKeysRead), ListAPIKeys)
+	authorized.POST("/apikeys", RequirePermission(PermAPIKeysManage), CreateAPIKey)
+	authorized.DELETE("/apikeys/:id", RequirePermission(PermAPIKeysManage), RevokeAPIKey)
 	//  =================使用 BasicAuth 中间件==================
 
 	// 任意协议的请求到testting，均调用startPage函数
@@ -118,24 +201,25 @@ func Handler() {
 	Route.GET("/cookie", Cookie)
 
 	// 简单的路由组: v1
-	v1 := Route.Group("/v1")
+	// v1和v2需要先通过/loginjson等登录接口获取访问令牌，服务调用方也可以使用scopes包含v1:write、v2:write的API key
+	v1 := Route.Group("/v1", APIKeyOr(JWTAuth()), RequireKeyScope(PermV1Write))
 	{
-		// curl -k -X POST "https://localhost/v1/postformwithquery?id=11&page=1"
+		// curl -k -H "Authorization: Bearer <access_token>" -X POST "https://localhost/v1/postformwithquery?id=11&page=1"
 		v1.POST("/postformwithquery", PostFormWithQuery)
 
 		// 映射查询字符串或表单参数
-		// curl -k -X POST --location "https://localhost/v1/postmultiformwithquery?ids\[a\]=11&ids\[b\]=22" --header "Content-Type: application/x-www-form-urlencoded" -d "names[first]=thinkerou&names[second]=tianou"
+		// curl -k -H "Authorization: Bearer <access_token>" -X POST --location "https://localhost/v1/postmultiformwithquery?ids\[a\]=11&ids\[b\]=22" --header "Content-Type: application/x-www-form-urlencoded" -d "names[first]=thinkerou&names[second]=tianou"
 		v1.POST("/postmultiformwithquery", PostMultiFormWithQuery)
 	}
 
 	// 简单的路由组: v2
-	v2 := Route.Group("/v2")
+	v2 := Route.Group("/v2", APIKeyOr(JWTAuth()), RequireKeyScope(PermV2Write))
 	{
-		// curl -k -X POST "https://localhost/v2/postformwithquery?id=11&page=1"
+		// curl -k -H "Authorization: Bearer <access_token>" -X POST "https://localhost/v2/postformwithquery?id=11&page=1"
 		v2.POST("/postformwithquery", PostFormWithQuery)
 
 		// 映射查询字符串或表单参数
-		// curl -k -X POST --location "https://localhost/v2/postmultiformwithquery?ids\[a\]=11&ids\[b\]=22" --header "Content-Type: application/x-www-form-urlencoded" -d "names[first]=thinkerou&names[second]=tianou"
+		// curl -k -H "Authorization: Bearer <access_token>" -X POST --location "https://localhost/v2/postmultiformwithquery?ids\[a\]=11&ids\[b\]=22" --header "Content-Type: application/x-www-form-urlencoded" -d "names[first]=thinkerou&names[second]=tianou"
 		v2.POST("/postmultiformwithquery", PostMultiFormWithQuery)
 	}
 
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/account"
+	"github.com/qinchy/hellogo/pkg/audit"
+	"github.com/qinchy/hellogo/pkg/oidc"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// 登录过程中保存在会话里的值，回调后删除
+const (
+	oidcStateKey    = "oidc_state"
+	oidcNonceKey    = "oidc_nonce"
+	oidcVerifierKey = "oidc_verifier"
+	oidcNextKey     = "oidc_next"
+)
+
+// defaultNext 登录后没有指定跳转地址时跳到首页
+const defaultNext = "/index"
+
+// RequireLogin 页面要求会话中有已登录的用户，没有时跳转到OIDC登录，没有配置OIDC时返回401。
+// 通过后把用户名设置到gin.AuthUserKey
+func RequireLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if sess, err := Session(c); err == nil {
+			name := sess.Get(sessionUserKey)
+			if u, err := Users.Get(name); err == nil && !u.Disabled {
+				c.Set(gin.AuthUserKey, name)
+				return
+			}
+		}
+		if OIDC == nil {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Redirect(http.StatusFound, "/auth/oidc/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+		c.Abort()
+	}
+}
+
+// OIDCLogin 生成state、nonce和PKCE参数保存到会话，然后跳转到认证服务器登录
+// 浏览器访问 https://localhost/auth/oidc/login?next=/posts/index
+func OIDCLogin(c *gin.Context) {
+	if OIDC == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "oidc login is not configured"})
+		return
+	}
+	sess, err := Session(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	login, err := oidc.NewLogin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	authURL, err := OIDC.AuthCodeURL(c.Request.Context(), login)
+	if err != nil {
+		Logger.Errorf("读取OIDC发现文档时出现异常：%s", err.Error())
+		c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider is unavailable"})
+		return
+	}
+	// 同一个会话只保留最近一次登录，回调使用SameSite=Lax时仍能带上会话cookie
+	sess.Set(oidcStateKey, login.State)
+	sess.Set(oidcNonceKey, login.Nonce)
+	sess.Set(oidcVerifierKey, login.Verifier)
+	sess.Set(oidcNextKey, safeNext(c.Query("next")))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback 认证服务器登录后的回调：校验state，用授权码和code_verifier换取ID令牌并校验，
+// 按声明找到或创建本地用户、同步角色，然后更换会话ID并写入用户
+func OIDCCallback(c *gin.Context) {
+	if OIDC == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "oidc login is not configured"})
+		return
+	}
+	sess, err := Session(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	login := &oidc.Login{
+		State:    sess.Get(oidcStateKey),
+		Nonce:    sess.Get(oidcNonceKey),
+		Verifier: sess.Get(oidcVerifierKey),
+	}
+	next := sess.Get(oidcNextKey)
+	// 无论成功与否，这些值都只能使用一次
+	for _, key := range []string{oidcStateKey, oidcNonceKey, oidcVerifierKey, oidcNextKey} {
+		sess.Delete(key)
+	}
+
+	if err := login.CheckState(c.Query("state")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": e, "error_description": c.Query("error_description")})
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "authorization code is required"})
+		return
+	}
+
+	token, err := OIDC.Exchange(c.Request.Context(), code, login)
+	if err != nil {
+		Logger.WithFields(logrus.Fields{
+			"client": c.ClientIP(),
+		}).Warnf("OIDC登录失败：%s", err.Error())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login failed"})
+		return
+	}
+	identity, err := OIDC.Identity(token)
+	if err != nil {
+		Logger.Warnf("OIDC登录失败：%s", err.Error())
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	name, status, err := syncOIDCUser(identity)
+	if err != nil {
+		Logger.WithFields(logrus.Fields{
+			"user":    identity.Name,
+			"issuer":  identity.Issuer,
+			"subject": identity.Subject,
+		}).Warnf("OIDC登录失败：%s", err.Error())
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := sess.Regenerate(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sess.Set(sessionUserKey, name)
+	err = Audit.Record(audit.Entry{
+		User:    name,
+		Action:  "oidc_login",
+		Allowed: true,
+		Method:  c.Request.Method,
+		Path:    c.Request.URL.Path,
+		Client:  c.ClientIP(),
+		Detail:  "sub=" + identity.Subject + " roles=" + strings.Join(identity.Roles, ","),
+	})
+	if err != nil {
+		Logger.Errorf("写入审计日志时出现异常：%s", err.Error())
+	}
+	c.Redirect(http.StatusFound, safeNext(next))
+}
+
+// syncOIDCUser 按ID令牌的iss和sub找到关联的本地用户，返回本地用户名。
+// 没有关联的用户时按用户名声明创建，同名的本地用户已经存在但没有关联这个身份时拒绝登录，
+// 需要先用 hellogo user link 关联。配置了角色映射时用映射结果覆盖本地角色
+func syncOIDCUser(identity *oidc.Identity) (string, int, error) {
+	u, err := Users.FindIdentity(identity.Issuer, identity.Subject)
+	if errors.Is(err, account.ErrNotFound) {
+		if _, err := Users.Get(identity.Name); err == nil {
+			return "", http.StatusForbidden, errors.New("local account " + identity.Name + " is not linked to this identity")
+		}
+		if !OIDC.AutoCreate() {
+			return "", http.StatusForbidden, errors.New("no local account for " + identity.Name)
+		}
+		_, err := Users.Provision(identity.Name, identity.Issuer, identity.Subject, identity.Roles)
+		switch {
+		case errors.Is(err, account.ErrInvalidName), errors.Is(err, account.ErrExists), errors.Is(err, account.ErrIdentityLinked):
+			return "", http.StatusForbidden, err
+		case err != nil:
+			return "", http.StatusInternalServerError, err
+		}
+		Logger.WithFields(logrus.Fields{
+			"user":    identity.Name,
+			"subject": identity.Subject,
+			"roles":   strings.Join(identity.Roles, ","),
+		}).Info("已为OIDC用户创建本地用户")
+		return identity.Name, http.StatusOK, nil
+	}
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	if u.Disabled {
+		return "", http.StatusForbidden, account.ErrDisabled
+	}
+	if OIDC.MapsRoles() && strings.Join(u.Roles, ",") != strings.Join(identity.Roles, ",") {
+		if err := Users.SetRoles(u.Name, identity.Roles); err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+	}
+	return u.Name, http.StatusOK, nil
+}
+
+// safeNext 只允许跳转到本站的路径，防止被用作开放重定向。
+// 带协议或主机、包含控制字符或反斜杠（浏览器会忽略或当作"/"）的地址一律跳到首页
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || unsafeRedirect(next) {
+		return defaultNext
+	}
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil || u.Opaque != "" ||
+		!strings.HasPrefix(u.Path, "/") || strings.HasPrefix(u.Path, "//") || unsafeRedirect(u.Path) {
+		return defaultNext
+	}
+	return u.RequestURI()
+}
+
+// unsafeRedirect 是否包含控制字符或反斜杠
+func unsafeRedirect(s string) bool {
+	for _, r := range s {
+		if unicode.IsControl(r) || r == '\\' {
+			return true
+		}
+	}
+	return false
+}
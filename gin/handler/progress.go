@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/progress"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// uploadIDHeader 客户端通过这个请求头（或upload_id查询参数）指定上传ID
+	uploadIDHeader = "X-Upload-ID"
+
+	// progressInterval 推送进度的间隔
+	progressInterval = 250 * time.Millisecond
+
+	// progressKeepAlive 进度没有变化时发送注释保持连接，避免被代理断开
+	progressKeepAlive = 15 * time.Second
+)
+
+// TrackProgress 请求带了上传ID时统计请求体的读取进度，供上传者通过/uploads/:id/progress订阅。
+// 进度按整个请求体计算，包括multipart的边界和其他表单字段。需要放在认证之后，以便记录上传者
+func TrackProgress(c *gin.Context) {
+	id := c.GetHeader(uploadIDHeader)
+	if id == "" {
+		id = c.Query("upload_id")
+	}
+	if id == "" {
+		c.Next()
+		return
+	}
+	if !progress.ValidID(id) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "upload id must be 16 to 64 characters of [A-Za-z0-9_-]"})
+		return
+	}
+
+	u, err := Progress.Start(id, uploader(c), c.Request.ContentLength)
+	if errors.Is(err, progress.ErrInUse) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.Request.Body = u.Body(c.Request.Body)
+	c.Header(uploadIDHeader, id)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	var reason string
+	if status >= http.StatusBadRequest {
+		reason = http.StatusText(status)
+	}
+	u.Finish(status, reason)
+}
+
+// UploadProgress 以Server-Sent Events推送上传进度：进度变化时发送progress事件，
+// 上传结束时发送completed或failed事件后关闭。上传开始后才能订阅，
+// 订阅者需要和上传者相同，不存在或者不属于订阅者的上传返回404，过早订阅的客户端可以稍后重试
+// curl -k -N -u foo:bar "https://localhost/uploads/<id>/progress"
+func UploadProgress(c *gin.Context) {
+	id := c.Param("id")
+	if !progress.ValidID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid upload id"})
+		return
+	}
+	u, err := Progress.Watch(id, uploader(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	// 禁止nginx缓冲，否则事件会攒到一起才发出
+	c.Header("X-Accel-Buffering", "no")
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	var last progress.Snapshot
+	lastSent := time.Now()
+	first := true
+	c.Stream(func(w io.Writer) bool {
+		snap := u.Snapshot()
+		if snap.Status.Final() {
+			c.SSEvent(string(snap.Status), snap)
+			return false
+		}
+		switch {
+		case first || snap != last:
+			c.SSEvent("progress", snap)
+			last, lastSent, first = snap, time.Now(), false
+		case time.Since(lastSent) > progressKeepAlive:
+			io.WriteString(w, ": keep-alive\n\n")
+			lastSent = time.Now()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-u.Done():
+		case <-c.Request.Context().Done():
+			return false
+		}
+		return true
+	})
+}
+
+// CleanUploadProgress 定时清理已经结束的上传进度
+func CleanUploadProgress() {
+	if removed := Progress.Cleanup(); removed > 0 {
+		Logger.WithFields(logrus.Fields{
+			"removed": removed,
+		}).Debug("已清理上传进度")
+	}
+}
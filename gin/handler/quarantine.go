@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/scan"
+	"github.com/qinchy/hellogo/pkg/upload"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// ListQuarantine 列出隔离区中的感染文件
+func ListQuarantine(c *gin.Context) {
+	entries, err := Quarantine.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"total": len(entries), "items": entries})
+}
+
+// DownloadQuarantined 下载隔离的文件供人工复核，始终作为二进制附件下载
+func DownloadQuarantined(c *gin.Context) {
+	entry, ok := quarantined(c)
+	if !ok {
+		return
+	}
+	content, err := Quarantine.Open(entry.File.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer content.Close()
+
+	ServeDownload(c, Download{
+		Content:     content,
+		Name:        entry.File.Name + ".quarantined",
+		ContentType: "application/octet-stream",
+		Hash:        entry.File.Hash,
+		ModTime:     entry.QuarantinedAt,
+	})
+}
+
+// ReleaseQuarantined 人工复核为误报后放行，文件以新的ID进入上传存储
+func ReleaseQuarantined(c *gin.Context) {
+	entry, ok := quarantined(c)
+	if !ok {
+		return
+	}
+	content, err := Quarantine.Open(entry.File.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer content.Close()
+
+	file, err := Uploads.Put(content, upload.Meta{
+		Name:        entry.File.Name,
+		ContentType: entry.File.ContentType,
+		Uploader:    entry.File.Uploader,
+		Reviewed:    true,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := Quarantine.Delete(entry.File.ID); err != nil {
+		Logger.Errorf("删除已放行的隔离文件时出现异常：%s", err.Error())
+	}
+
+	Logger.WithFields(logrus.Fields{
+		"quarantine_id": entry.File.ID,
+		"id":            file.ID,
+		"signature":     entry.Result.Signature,
+		"reviewer":      c.GetString(gin.AuthUserKey),
+	}).Warn("隔离文件已人工放行")
+	c.JSON(http.StatusOK, file)
+}
+
+// DeleteQuarantined 删除隔离的文件
+func DeleteQuarantined(c *gin.Context) {
+	entry, ok := quarantined(c)
+	if !ok {
+		return
+	}
+	if err := Quarantine.Delete(entry.File.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	Logger.WithFields(logrus.Fields{
+		"quarantine_id": entry.File.ID,
+		"signature":     entry.Result.Signature,
+		"reviewer":      c.GetString(gin.AuthUserKey),
+	}).Info("隔离文件已删除")
+	c.Status(http.StatusNoContent)
+}
+
+// quarantined 取出路径中的隔离文件
+func quarantined(c *gin.Context) (*scan.Entry, bool) {
+	entry, err := Quarantine.Get(c.Param("id"))
+	if errors.Is(err, scan.ErrNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return entry, true
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/session"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// sessionKey 会话在gin.Context中的键
+	sessionKey = "hellogo/session"
+	// sessionUserKey 会话中保存登录用户名的键
+	sessionUserKey = "user"
+)
+
+// sessionState 请求中的会话，第一次调用Session时才加载
+type sessionState struct {
+	c       *gin.Context
+	session *session.Session
+	err     error
+	loaded  bool
+	saved   bool
+}
+
+// save 把修改过的会话写入cookie，必须在响应头发出之前调用
+func (st *sessionState) save() {
+	if st.saved || st.session == nil {
+		return
+	}
+	st.saved = true
+	if err := Sessions.Save(st.c.Writer, st.session); err != nil {
+		Logger.WithFields(logrus.Fields{
+			"path": st.c.Request.URL.Path,
+		}).Errorf("保存会话时出现异常：%s", err.Error())
+	}
+}
+
+// sessionWriter 第一次写响应之前保存会话，保证Set-Cookie能随响应头发出
+type sessionWriter struct {
+	gin.ResponseWriter
+	state *sessionState
+}
+
+func (w *sessionWriter) Write(data []byte) (int, error) {
+	w.state.save()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *sessionWriter) WriteString(s string) (int, error) {
+	w.state.save()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *sessionWriter) WriteHeaderNow() {
+	w.state.save()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *sessionWriter) Flush() {
+	w.state.save()
+	w.ResponseWriter.Flush()
+}
+
+// WithSession 为请求启用会话，处理器通过Session获取
+func WithSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := &sessionState{c: c}
+		c.Set(sessionKey, state)
+		c.Writer = &sessionWriter{ResponseWriter: c.Writer, state: state}
+		c.Next()
+		// 处理器没有写响应体时在这里保存
+		if !c.Writer.Written() {
+			state.save()
+		}
+	}
+}
+
+// Session 当前请求的会话，没有使用WithSession中间件时返回ErrNotFound
+func Session(c *gin.Context) (*session.Session, error) {
+	v, ok := c.Get(sessionKey)
+	if !ok {
+		return nil, session.ErrNotFound
+	}
+	state := v.(*sessionState)
+	if !state.loaded {
+		state.loaded = true
+		state.session, state.err = Sessions.Load(c.Request)
+	}
+	return state.session, state.err
+}
+
+// CleanExpiredSessions 删除存储中已过期的会话
+func CleanExpiredSessions() {
+	removed, err := Sessions.Cleanup()
+	if err != nil {
+		Logger.Errorf("清理会话时出现异常：%s", err.Error())
+		return
+	}
+	if removed > 0 {
+		Logger.WithFields(logrus.Fields{
+			"removed": removed,
+		}).Debug("已清理过期的会话")
+	}
+}
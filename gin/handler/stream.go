@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/sniff"
+	"github.com/qinchy/hellogo/pkg/upload"
+	"github.com/sirupsen/logrus"
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+// StreamUpload 流式上传：逐个读取multipart中的文件直接写入上传存储，边写边计算哈希和检查大小，
+// 不像c.FormFile/c.MultipartForm那样先把超过MaxMultipartMemory的部分落到临时文件。
+// 任意字段名的文件都会保存，其他表单字段被忽略；中途出错时已保存的文件会被删除
+// curl -k -X POST https://localhost/streamupload -F "file=@big.iso" -F "file=@notes.txt"
+func StreamUpload(c *gin.Context) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		uploadError(c, err)
+		return
+	}
+
+	limits := uploadLimits(c)
+	stored := make([]*upload.File, 0)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			discardUploads(stored)
+			uploadError(c, malformed(err))
+			return
+		}
+		if part.FileName() == "" {
+			// 普通表单字段
+			_, err = io.Copy(io.Discard, part)
+			part.Close()
+			if err != nil {
+				discardUploads(stored)
+				uploadError(c, malformed(err))
+				return
+			}
+			continue
+		}
+
+		if limits.MaxFiles > 0 && len(stored) >= limits.MaxFiles {
+			part.Close()
+			discardUploads(stored)
+			uploadError(c, fmt.Errorf("%w: limit %d", upload.ErrTooManyFiles, limits.MaxFiles))
+			return
+		}
+		f, err := streamPart(c, part, limits)
+		part.Close()
+		if err != nil {
+			discardUploads(stored)
+			uploadError(c, err)
+			return
+		}
+		stored = append(stored, f)
+	}
+	if len(stored) == 0 {
+		uploadError(c, upload.ErrNoFile)
+		return
+	}
+
+	Logger.WithFields(logrus.Fields{
+		"count": len(stored),
+	}).Info("流式上传完成")
+	c.JSON(http.StatusOK, gin.H{"count": len(stored), "files": stored})
+}
+
+// streamPart 嗅探文件开头的内容检查类型，然后把整个part写入上传存储
+func streamPart(c *gin.Context, part *multipart.Part, limits upload.Limits) (*upload.File, error) {
+	name := part.FileName()
+	br := bufio.NewReaderSize(upload.LimitReader(partReader{r: part}, name, limits.MaxFileSize), sniff.HeadSize)
+	head, err := br.Peek(sniff.HeadSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	contentType, err := uploadPolicy(c).Check(head, name, part.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	return Uploads.Put(br, upload.Meta{Name: name, ContentType: contentType, Uploader: uploader(c)})
+}
+
+// discardUploads 删除同一个请求中已经保存的文件
+func discardUploads(files []*upload.File) {
+	for _, f := range files {
+		if err := Uploads.Delete(f.ID); err != nil {
+			Logger.WithFields(logrus.Fields{"id": f.ID}).Errorf("删除未完成请求中的文件时出现异常：%s", err.Error())
+		}
+	}
+}
+
+// malformed 把multipart解析错误标记为ErrMalformed，请求体超过限制的错误原样返回
+func malformed(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return err
+	}
+	return fmt.Errorf("%w: %s", upload.ErrMalformed, err.Error())
+}
+
+// partReader 区分读取请求体的错误和写入存储的错误，前者是客户端的问题
+type partReader struct {
+	r io.Reader
+}
+
+func (p partReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err != nil && err != io.EOF {
+		err = malformed(err)
+	}
+	return n, err
+}
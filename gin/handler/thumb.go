@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/thumb"
+	"github.com/qinchy/hellogo/pkg/upload"
+	"github.com/sirupsen/logrus"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FileThumb 输出图片的缩略图，size为配置的尺寸之一，默认最小的尺寸
+// curl -k -u foo:bar -o thumb.jpg "https://localhost/files/<id>/thumb?size=256"
+func FileThumb(c *gin.Context) {
+	f, ok := ownedFile(c)
+	if !ok {
+		return
+	}
+	sizes := thumb.ParseSizes(Conf.ThumbnailSizes)
+	size, ok := thumbSize(c.Query("size"), sizes)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("size must be one of %v", sizes)})
+		return
+	}
+	if !thumb.Supported(f.ContentType) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no thumbnail for this file"})
+		return
+	}
+
+	content, err := Uploads.OpenThumb(f.Hash, size)
+	if errors.Is(err, os.ErrNotExist) {
+		// 配置了新的尺寸或者不是通过/singleupload上传的图片，按需生成
+		if err = generateThumbs(f, nil, []int{size}); err == nil {
+			content, err = Uploads.OpenThumb(f.Hash, size)
+		}
+	}
+	if errors.Is(err, thumb.ErrInvalidImage) || errors.Is(err, thumb.ErrTooManyPixels) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no thumbnail for this file: " + err.Error()})
+		return
+	}
+	if err != nil {
+		Logger.WithFields(logrus.Fields{"id": f.ID, "size": size}).Errorf("读取缩略图时出现异常：%s", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer content.Close()
+
+	ServeDownload(c, Download{
+		Content:     content,
+		Name:        strings.TrimSuffix(f.Name, filepath.Ext(f.Name)) + "_" + strconv.Itoa(size) + thumb.Ext(f.ContentType),
+		ContentType: thumb.ContentType(f.ContentType),
+		Hash:        f.Hash + "-" + strconv.Itoa(size),
+		ModTime:     f.CreatedAt,
+		Inline:      true,
+	})
+}
+
+// saveImage 去除图片的EXIF等元数据后保存，记录尺寸并生成缩略图。
+// 缩略图生成失败不影响上传，访问时会再按需生成
+func saveImage(fh *multipart.FileHeader, meta upload.Meta) (*upload.File, error) {
+	src, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	// 大小已经由上传限制检查过，图片需要完整读入内存才能解码
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := thumb.Inspect(data)
+	if err != nil {
+		return nil, err
+	}
+	stripped, err := thumb.Strip(data, meta.ContentType)
+	if err != nil {
+		return nil, err
+	}
+
+	meta.Name = fh.Filename
+	meta.Width, meta.Height = info.Width, info.Height
+	stored, err := Uploads.Put(bytes.NewReader(stripped), meta)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := generateThumbs(stored, data, thumb.ParseSizes(Conf.ThumbnailSizes)); err != nil {
+		Logger.WithFields(logrus.Fields{
+			"id":     stored.ID,
+			"width":  info.Width,
+			"height": info.Height,
+		}).Warnf("生成缩略图时出现异常：%s", err.Error())
+	}
+	return stored, nil
+}
+
+// generateThumbs 生成还没有的缩略图。data为空时从存储中读取内容，
+// 存储的内容保留了EXIF方向，同样按拍摄方向校正
+func generateThumbs(f *upload.File, data []byte, sizes []int) error {
+	missing := make([]int, 0, len(sizes))
+	for _, size := range sizes {
+		if existing, err := Uploads.OpenThumb(f.Hash, size); err == nil {
+			existing.Close()
+			continue
+		}
+		missing = append(missing, size)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if data == nil {
+		content, err := Uploads.Open(f)
+		if err != nil {
+			return err
+		}
+		data, err = io.ReadAll(content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	thumbs, err := thumb.Generate(data, f.ContentType, missing, Conf.ImageMaxPixels)
+	if err != nil {
+		return err
+	}
+	for size, b := range thumbs {
+		if err := Uploads.PutThumb(f.Hash, size, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// thumbSize 解析请求的缩略图尺寸，只允许配置中的尺寸，避免任意尺寸占用资源
+func thumbSize(s string, sizes []int) (int, bool) {
+	if len(sizes) == 0 {
+		return 0, false
+	}
+	if s == "" {
+		return sizes[0], true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false
+	}
+	for _, size := range sizes {
+		if size == n {
+			return n, true
+		}
+	}
+	return 0, false
+}
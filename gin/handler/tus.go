@@ -0,0 +1,262 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/scan"
+	"github.com/qinchy/hellogo/pkg/sniff"
+	"github.com/qinchy/hellogo/pkg/tus"
+	"github.com/qinchy/hellogo/pkg/upload"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// TusResumable tus协议的公共中间件：统一返回Tus-Resumable头并校验客户端的协议版本
+func TusResumable(c *gin.Context) {
+	c.Header("Tus-Resumable", tus.Version)
+	// 浏览器客户端需要读取这些响应头
+	c.Header("Access-Control-Expose-Headers", "Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Metadata, Upload-Expires")
+
+	if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tus.Version {
+		c.Header("Tus-Version", tus.Version)
+		c.AbortWithStatus(http.StatusPreconditionFailed)
+		return
+	}
+	c.Next()
+}
+
+// TusOptions 返回服务端支持的版本和扩展
+func TusOptions(c *gin.Context) {
+	c.Header("Tus-Version", tus.Version)
+	c.Header("Tus-Extension", tus.Extensions)
+	if max := TusUploads.MaxSize(); max > 0 {
+		c.Header("Tus-Max-Size", strconv.FormatInt(max, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// TusCreate 创建上传（creation扩展）
+func TusCreate(c *gin.Context) {
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Length"})
+		return
+	}
+	metadata, err := tus.ParseMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	info, err := TusUploads.Create(length, metadata, uploader(c))
+	if err != nil {
+		tusError(c, err)
+		return
+	}
+
+	Logger.WithFields(logrus.Fields{
+		"id":       info.ID,
+		"length":   info.Length,
+		"metadata": info.Metadata,
+	}).Info("创建断点续传上传")
+	c.Header("Location", tusLocation(c, info.ID))
+	c.Header("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+
+	// 空文件创建即完成
+	if info.Done() {
+		if _, err := TusUploads.Complete(info.ID, storeTus); err != nil {
+			tusError(c, err)
+			return
+		}
+	}
+	c.Status(http.StatusCreated)
+}
+
+// TusHead 查询上传偏移量，用于断点续传。数据已全部接收但上次转存失败时在这里重试，
+// 转存成功前不会报告上传已完成
+func TusHead(c *gin.Context) {
+	info, err := ownedTus(c)
+	if err == nil && info.Pending() {
+		info, err = TusUploads.Complete(info.ID, storeTus)
+	}
+	if err != nil {
+		tusError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(info.Length, 10))
+	if len(info.Metadata) > 0 {
+		c.Header("Upload-Metadata", tus.EncodeMetadata(info.Metadata))
+	}
+	if !info.Done() {
+		c.Header("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	c.Status(http.StatusOK)
+}
+
+// TusPatch 从指定偏移量追加数据，全部接收后转存到上传存储
+func TusPatch(c *gin.Context) {
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Offset"})
+		return
+	}
+
+	if _, err := ownedTus(c); err != nil {
+		tusError(c, err)
+		return
+	}
+
+	info, err := TusUploads.Append(c.Param("id"), offset, c.Request.Body)
+	// 上次数据全部接收后转存失败，重试转存
+	if errors.Is(err, tus.ErrCompleted) && info.Pending() && offset == info.Offset {
+		err = nil
+	}
+	if err != nil {
+		tusError(c, err)
+		return
+	}
+
+	if info.Pending() {
+		if info, err = TusUploads.Complete(info.ID, storeTus); err != nil {
+			tusError(c, err)
+			return
+		}
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	if !info.Done() {
+		c.Header("Upload-Expires", info.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// TusDelete 终止上传（termination扩展）
+func TusDelete(c *gin.Context) {
+	// 过期的上传同样可以终止
+	if _, err := ownedTus(c); err != nil && !errors.Is(err, tus.ErrExpired) {
+		tusError(c, err)
+		return
+	}
+	if err := TusUploads.Terminate(c.Param("id")); err != nil {
+		tusError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ownedTus 取出路径中的上传，上传属于其他用户时按不存在处理，不暴露其他用户的上传是否存在。
+// 匿名创建的上传任何人都可以继续
+func ownedTus(c *gin.Context) (*tus.Info, error) {
+	info, err := TusUploads.Get(c.Param("id"))
+	if info != nil && info.Uploader != "" && info.Uploader != uploader(c) {
+		return nil, tus.ErrNotFound
+	}
+	return info, err
+}
+
+// CleanExpiredTusUploads 定时清理过期的断点续传上传
+func CleanExpiredTusUploads() {
+	removed, err := TusUploads.Cleanup()
+	if err != nil {
+		Logger.Errorf("清理过期上传时出现异常：%s", err.Error())
+		return
+	}
+	if removed > 0 {
+		Logger.WithFields(logrus.Fields{"removed": removed}).Info("已清理过期的断点续传上传")
+	}
+}
+
+// storeTus 把接收完的数据转存到上传存储，文件名取自元数据中的filename，返回转存后的文件ID
+func storeTus(info *tus.Info, f *os.File) (string, error) {
+	filename := info.Metadata["filename"]
+	if filename == "" {
+		filename = info.ID
+	}
+
+	// 类型不符合策略时直接丢弃这次上传
+	contentType, content, err := sniff.DetectReader(f)
+	if err != nil {
+		return "", err
+	}
+	if err := DefaultUploadPolicy().Verify(contentType, filename, info.Metadata["filetype"]); err != nil {
+		TusUploads.Terminate(info.ID)
+		return "", err
+	}
+	contentType = sniff.Resolve(contentType, filename)
+
+	file, err := Uploads.Put(content, upload.Meta{
+		Name:        filename,
+		ContentType: contentType,
+		Uploader:    info.Uploader,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	Logger.WithFields(logrus.Fields{
+		"id":   info.ID,
+		"file": filename,
+		"hash": file.Hash,
+		"size": file.Size,
+	}).Info("断点续传上传完成")
+	return file.ID, nil
+}
+
+// tusLocation 上传的URL
+func tusLocation(c *gin.Context, id string) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + "/tus/" + id
+}
+
+// tusError 把存储的错误转换为对应的状态码
+func tusError(c *gin.Context, err error) {
+	var sniffErr *sniff.Error
+	if errors.As(err, &sniffErr) {
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, sniffErr)
+		return
+	}
+	var infectedErr *scan.InfectedError
+	if errors.As(err, &infectedErr) {
+		TusUploads.Terminate(c.Param("id"))
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "signature": infectedErr.Signature})
+		return
+	}
+
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, tus.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, tus.ErrExpired):
+		status = http.StatusGone
+	case errors.Is(err, tus.ErrOffsetMismatch), errors.Is(err, tus.ErrCompleted):
+		status = http.StatusConflict
+	case errors.Is(err, tus.ErrTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, tus.ErrLocked):
+		status = http.StatusLocked
+	case errors.Is(err, scan.ErrUnavailable):
+		status = http.StatusServiceUnavailable
+	}
+
+	if status >= http.StatusInternalServerError {
+		Logger.WithFields(logrus.Fields{"id": c.Param("id")}).Errorf("断点续传上传出现异常：%s", err.Error())
+	}
+	if c.Request.Method == http.MethodHead {
+		c.AbortWithStatus(status)
+		return
+	}
+	c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+}
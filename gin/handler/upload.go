@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/account"
+	"github.com/qinchy/hellogo/pkg/scan"
+	"github.com/qinchy/hellogo/pkg/sniff"
+	"github.com/qinchy/hellogo/pkg/thumb"
+	"github.com/qinchy/hellogo/pkg/upload"
+	"github.com/sirupsen/logrus"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+const (
+	// uploadLimitsKey 上传限制在gin.Context中的键
+	uploadLimitsKey = "upload_limits"
+
+	// uploadPolicyKey 上传类型策略在gin.Context中的键
+	uploadPolicyKey = "upload_policy"
+)
+
+// formOverhead 请求体中除文件外的表单字段和multipart边界预留的大小
+const formOverhead = 1 << 20
+
+// LimitUpload 为路由设置上传限制，并按限制截断过大的请求体
+func LimitUpload(limits upload.Limits) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limits.MaxFileSize > 0 && limits.MaxFiles > 0 {
+			max := limits.MaxFileSize*int64(limits.MaxFiles) + formOverhead
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, max)
+		}
+		c.Set(uploadLimitsKey, limits)
+		c.Next()
+	}
+}
+
+// DefaultUploadLimits 全局配置中的默认上传限制
+func DefaultUploadLimits() upload.Limits {
+	return upload.Limits{
+		MaxFileSize: Conf.UploadMaxFileSize,
+		MaxFiles:    Conf.UploadMaxFiles,
+	}
+}
+
+// UploadPolicy 为路由设置允许上传的文件类型
+func UploadPolicy(policy sniff.Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(uploadPolicyKey, policy)
+		c.Next()
+	}
+}
+
+// DefaultUploadPolicy 全局配置中的默认类型策略
+func DefaultUploadPolicy() sniff.Policy {
+	return sniff.Policy{
+		Allow:  sniff.ParseList(Conf.UploadAllowTypes),
+		Deny:   sniff.ParseList(Conf.UploadDenyTypes),
+		Strict: Conf.UploadStrictTypes,
+	}
+}
+
+// SingleUpload 通过表单上传单个文件
+func SingleUpload(c *gin.Context) {
+	// 单文件
+	file, err := c.FormFile("file")
+	if err != nil {
+		uploadError(c, err)
+		return
+	}
+	if err := uploadLimits(c).Check([]*multipart.FileHeader{file}); err != nil {
+		uploadError(c, err)
+		return
+	}
+	contentType, err := checkUploadType(c, file)
+	if err != nil {
+		uploadError(c, err)
+		return
+	}
+
+	// 内容按SHA-256保存，相同内容只保存一份；图片去除EXIF后保存并生成缩略图
+	meta := upload.Meta{ContentType: contentType, Uploader: uploader(c)}
+	var stored *upload.File
+	if thumb.Supported(contentType) {
+		stored, err = saveImage(file, meta)
+	} else {
+		stored, err = Uploads.Save(file, meta)
+	}
+	if err != nil {
+		uploadError(c, err)
+		return
+	}
+
+	Logger.WithFields(logrus.Fields{
+		"id":   stored.ID,
+		"file": stored.Name,
+		"hash": stored.Hash,
+		"size": stored.Size,
+	}).Info("文件上传完成")
+	c.JSON(http.StatusOK, stored)
+}
+
+// MultiUpload 通过表单上传多个文件
+func MultiUpload(c *gin.Context) {
+	// Multipart form
+	form, err := c.MultipartForm()
+	if err != nil {
+		uploadError(c, err)
+		return
+	}
+	files := form.File["upload[]"]
+	if err := uploadLimits(c).Check(files); err != nil {
+		uploadError(c, err)
+		return
+	}
+	// 先检查全部文件的类型，避免只保存了一部分
+	contentTypes := make([]string, len(files))
+	for i, file := range files {
+		if contentTypes[i], err = checkUploadType(c, file); err != nil {
+			uploadError(c, err)
+			return
+		}
+	}
+
+	stored := make([]*upload.File, 0, len(files))
+	for i, file := range files {
+		f, err := Uploads.Save(file, upload.Meta{ContentType: contentTypes[i], Uploader: uploader(c)})
+		if err != nil {
+			uploadError(c, err)
+			return
+		}
+		stored = append(stored, f)
+	}
+
+	Logger.WithFields(logrus.Fields{
+		"count": len(files),
+	}).Info("文件上传完成")
+	c.JSON(http.StatusOK, gin.H{"count": len(files), "files": stored})
+}
+
+// CollectUploadGarbage 定时回收没有被引用的上传内容
+func CollectUploadGarbage() {
+	removed, freed, err := Uploads.GC(24 * time.Hour)
+	if err != nil {
+		Logger.Errorf("回收上传内容时出现异常：%s", err.Error())
+		return
+	}
+	if removed > 0 {
+		Logger.WithFields(logrus.Fields{
+			"removed": removed,
+			"freed":   freed,
+		}).Info("已回收未被引用的上传内容")
+	}
+}
+
+// uploader 上传者，通过认证时为认证用户，匿名上传为空，不属于任何用户
+func uploader(c *gin.Context) string {
+	return c.GetString(gin.AuthUserKey)
+}
+
+// ownedBy 文件是否属于用户，匿名上传的文件不属于任何用户。
+// 保留名称同样不匹配，避免早期以anonymous记录的匿名上传被同名用户访问
+func ownedBy(f *upload.File, user string) bool {
+	return user != "" && !account.Reserved(user) && f.Uploader == user
+}
+
+// uploadLimits 取出路由的上传限制，没有设置时使用默认限制
+func uploadLimits(c *gin.Context) upload.Limits {
+	if v, ok := c.Get(uploadLimitsKey); ok {
+		return v.(upload.Limits)
+	}
+	return DefaultUploadLimits()
+}
+
+// uploadPolicy 取出路由的类型策略，没有设置时使用默认策略
+func uploadPolicy(c *gin.Context) sniff.Policy {
+	if v, ok := c.Get(uploadPolicyKey); ok {
+		return v.(sniff.Policy)
+	}
+	return DefaultUploadPolicy()
+}
+
+// checkUploadType 嗅探文件的真实类型并按路由策略检查，返回真实类型
+func checkUploadType(c *gin.Context, file *multipart.FileHeader) (string, error) {
+	f, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, sniff.HeadSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return uploadPolicy(c).Check(head[:n], file.Filename, file.Header.Get("Content-Type"))
+}
+
+// uploadError 把上传错误转换为对应状态码的JSON响应
+func uploadError(c *gin.Context, err error) {
+	var sniffErr *sniff.Error
+	if errors.As(err, &sniffErr) {
+		Logger.WithFields(logrus.Fields{
+			"path":      c.Request.URL.Path,
+			"code":      sniffErr.Code,
+			"detected":  sniffErr.Detected,
+			"declared":  sniffErr.Declared,
+			"extension": sniffErr.Extension,
+		}).Warn("上传文件类型被拒绝")
+		c.AbortWithStatusJSON(http.StatusUnsupportedMediaType, sniffErr)
+		return
+	}
+
+	var infectedErr *scan.InfectedError
+	if errors.As(err, &infectedErr) {
+		Logger.WithFields(logrus.Fields{
+			"path":      c.Request.URL.Path,
+			"signature": infectedErr.Signature,
+			"scanner":   infectedErr.Scanner,
+			"uploader":  uploader(c),
+		}).Warn("上传文件被判定为感染，已隔离")
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "signature": infectedErr.Signature})
+		return
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, scan.ErrUnavailable):
+		status = http.StatusServiceUnavailable
+	case errors.As(err, &maxBytesErr), errors.Is(err, upload.ErrTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, upload.ErrTooManyFiles),
+		errors.Is(err, upload.ErrNoFile),
+		errors.Is(err, upload.ErrMalformed),
+		errors.Is(err, thumb.ErrInvalidImage),
+		errors.Is(err, http.ErrMissingFile),
+		errors.Is(err, http.ErrNotMultipart),
+		errors.Is(err, multipart.ErrMessageTooLarge):
+		status = http.StatusBadRequest
+	}
+
+	entry := Logger.WithFields(logrus.Fields{
+		"path":   c.Request.URL.Path,
+		"status": status,
+	})
+	if status >= http.StatusInternalServerError {
+		entry.Errorf("上传文件时出现异常：%s", err.Error())
+	} else {
+		entry.Warnf("上传请求被拒绝：%s", err.Error())
+	}
+	c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+}
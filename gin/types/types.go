@@ -35,8 +35,28 @@ type LoginForm struct {
 	Password string `form:"password" json:"password" xml:"password" binding:"required"`
 }
 
+// RefreshForm 刷新或撤销令牌的请求
+type RefreshForm struct {
+	RefreshToken string `form:"refresh_token" json:"refresh_token" xml:"refresh_token" binding:"required"`
+}
+
+// APIKeyForm 创建API key的请求，scopes为权限模式，如deadletters:read、*:read
+type APIKeyForm struct {
+	Name      string     `json:"name" binding:"required,max=64"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
 type Booking struct {
 	CheckIn time.Time `form:"check_in" binding:"required,bookabledate" time_format:"2006-01-02"`
 	// CheckOut应该大于当前时间，且要大于CheckIn，日期格式为YYYY-MM-DD
 	CheckOut time.Time `form:"check_out" binding:"required,gtfield=CheckIn,bookabledate" time_format:"2006-01-02"`
 }
+
+// Record 读取文件时每一行对应的记录
+type Record struct {
+	ID      string    `json:"id" binding:"required,uuid"`
+	Name    string    `json:"name" binding:"required"`
+	Address string    `json:"address"`
+	CheckIn time.Time `json:"check_in" binding:"required,bookabledate"`
+}
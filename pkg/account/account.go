@@ -0,0 +1,464 @@
+package account
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+var (
+	// ErrNotFound 用户不存在
+	ErrNotFound = errors.New("user not found")
+	// ErrExists 用户已存在
+	ErrExists = errors.New("user already exists")
+	// ErrInvalidName 用户名为空、过长或包含空白、控制字符和冒号
+	ErrInvalidName = errors.New("invalid user name")
+	// ErrWeakPassword 密码太短或超过bcrypt支持的72字节
+	ErrWeakPassword = errors.New("password must be 8 to 72 bytes")
+	// ErrInvalidCredentials 用户名或密码错误
+	ErrInvalidCredentials = errors.New("invalid user name or password")
+	// ErrDisabled 用户已被禁用
+	ErrDisabled = errors.New("user is disabled")
+	// ErrIdentityLinked 外部身份已经关联了其他用户
+	ErrIdentityLinked = errors.New("external identity is linked to another user")
+)
+
+const (
+	// MinPasswordLength 密码的最小长度
+	MinPasswordLength = 8
+	// maxPasswordLength bcrypt只使用前72字节，更长的密码直接拒绝
+	maxPasswordLength = 72
+	// maxNameLength 用户名的最大长度
+	maxNameLength = 64
+)
+
+// reservedNames 不能作为用户名的名称，早期的匿名上传以anonymous作为上传者
+var reservedNames = map[string]bool{
+	"anonymous": true,
+}
+
+// User 一个用户，密码只保存bcrypt哈希
+type User struct {
+	Name         string    `json:"name"`
+	PasswordHash string    `json:"password_hash"`
+	Disabled     bool      `json:"disabled,omitempty"`
+	Roles        []string  `json:"roles,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// Issuer、Subject 关联的OIDC身份（签发者和sub），只有关联了身份的用户才能通过OIDC登录
+	Issuer  string `json:"issuer,omitempty"`
+	Subject string `json:"subject,omitempty"`
+}
+
+// Store 保存在本地JSON文件中的用户，每次修改都整体重写文件。
+// 命令行和服务器可以同时使用同一个文件，修改前会先读取文件的最新内容
+type Store struct {
+	path string
+	cost int
+
+	mu      sync.RWMutex
+	users   map[string]*User
+	modTime time.Time
+	// dummyHash 用户不存在时也做一次bcrypt比较，避免通过响应时间判断用户是否存在
+	dummyHash []byte
+	// verified 校验通过的密码的HMAC，BasicAuth每个请求都要校验密码，避免每次都计算bcrypt
+	verified map[string][]byte
+	key      []byte
+}
+
+// Open 打开用户文件，文件不存在时为空的用户集合，第一次修改时创建
+func Open(path string, cost int) (*Store, error) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	dummyHash, err := bcrypt.GenerateFromPassword(key[:16], cost)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		path:      path,
+		cost:      cost,
+		users:     map[string]*User{},
+		dummyHash: dummyHash,
+		verified:  map[string][]byte{},
+		key:       key,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload 文件被其他进程修改时重新加载
+func (s *Store) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Len 用户数量
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users)
+}
+
+// Get 获取用户的副本
+func (s *Store) Get(name string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *u
+	return &copied, nil
+}
+
+// List 按用户名排序列出全部用户
+func (s *Store) List() []*User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]*User, 0, len(s.users))
+	for _, u := range s.users {
+		copied := *u
+		list = append(list, &copied)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Create 创建用户
+func (s *Store) Create(name, password string) (*User, error) {
+	if !validName(name) {
+		return nil, ErrInvalidName
+	}
+	hash, err := s.hash(password)
+	if err != nil {
+		return nil, err
+	}
+
+	var created *User
+	err = s.update(func() error {
+		if _, ok := s.users[name]; ok {
+			return ErrExists
+		}
+		now := time.Now()
+		created = &User{Name: name, PasswordHash: hash, CreatedAt: now, UpdatedAt: now}
+		s.users[name] = created
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	copied := *created
+	return &copied, nil
+}
+
+// Provision 创建没有密码并关联了OIDC身份的用户，只能通过OIDC登录，之后可以用SetPassword设置密码
+func (s *Store) Provision(name, issuer, subject string, roles []string) (*User, error) {
+	if !validName(name) {
+		return nil, ErrInvalidName
+	}
+	if issuer == "" || subject == "" {
+		return nil, errors.New("issuer and subject are required")
+	}
+	var created *User
+	err := s.update(func() error {
+		if _, ok := s.users[name]; ok {
+			return ErrExists
+		}
+		if s.findIdentity(issuer, subject) != nil {
+			return ErrIdentityLinked
+		}
+		now := time.Now()
+		created = &User{
+			Name:      name,
+			Roles:     append([]string(nil), roles...),
+			Issuer:    issuer,
+			Subject:   subject,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		s.users[name] = created
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	copied := *created
+	return &copied, nil
+}
+
+// FindIdentity 找到关联了OIDC身份的用户
+func (s *Store) FindIdentity(issuer, subject string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u := s.findIdentity(issuer, subject)
+	if u == nil {
+		return nil, ErrNotFound
+	}
+	copied := *u
+	return &copied, nil
+}
+
+// findIdentity 调用方需持有锁
+func (s *Store) findIdentity(issuer, subject string) *User {
+	if issuer == "" || subject == "" {
+		return nil
+	}
+	for _, u := range s.users {
+		if u.Issuer == issuer && u.Subject == subject {
+			return u
+		}
+	}
+	return nil
+}
+
+// Link 为已有用户关联OIDC身份，之后可以通过OIDC登录；issuer和subject为空时取消关联
+func (s *Store) Link(name, issuer, subject string) error {
+	if (issuer == "") != (subject == "") {
+		return errors.New("issuer and subject must both be set or both be empty")
+	}
+	return s.update(func() error {
+		u, ok := s.users[name]
+		if !ok {
+			return ErrNotFound
+		}
+		if other := s.findIdentity(issuer, subject); other != nil && other != u {
+			return ErrIdentityLinked
+		}
+		u.Issuer, u.Subject = issuer, subject
+		u.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// SetPassword 修改密码
+func (s *Store) SetPassword(name, password string) error {
+	hash, err := s.hash(password)
+	if err != nil {
+		return err
+	}
+	return s.modify(name, func(u *User) {
+		u.PasswordHash = hash
+	})
+}
+
+// SetDisabled 禁用或启用用户，禁用的用户无法通过认证
+func (s *Store) SetDisabled(name string, disabled bool) error {
+	return s.modify(name, func(u *User) {
+		u.Disabled = disabled
+	})
+}
+
+// SetRoles 设置用户的角色，权限由角色决定
+func (s *Store) SetRoles(name string, roles []string) error {
+	roles = append([]string(nil), roles...)
+	return s.modify(name, func(u *User) {
+		u.Roles = roles
+	})
+}
+
+// Delete 删除用户
+func (s *Store) Delete(name string) error {
+	return s.update(func() error {
+		if _, ok := s.users[name]; !ok {
+			return ErrNotFound
+		}
+		delete(s.users, name)
+		return nil
+	})
+}
+
+// Authenticate 校验用户名和密码，返回用户的副本
+func (s *Store) Authenticate(name, password string) (*User, error) {
+	s.mu.RLock()
+	u, ok := s.users[name]
+	var copied User
+	var cached []byte
+	if ok {
+		copied = *u
+		cached = s.verified[name]
+	}
+	s.mu.RUnlock()
+
+	// 外部认证创建的用户没有密码，和不存在的用户一样处理
+	if !ok || copied.PasswordHash == "" {
+		bcrypt.CompareHashAndPassword(s.dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	mac := s.mac(copied.PasswordHash, password)
+	if cached == nil || !hmac.Equal(cached, mac) {
+		if bcrypt.CompareHashAndPassword([]byte(copied.PasswordHash), []byte(password)) != nil {
+			return nil, ErrInvalidCredentials
+		}
+		s.mu.Lock()
+		// 校验期间密码可能已被修改，只缓存仍然有效的结果
+		if u, ok := s.users[name]; ok && u.PasswordHash == copied.PasswordHash {
+			s.verified[name] = mac
+		}
+		s.mu.Unlock()
+	}
+	if copied.Disabled {
+		return nil, ErrDisabled
+	}
+	return &copied, nil
+}
+
+// modify 修改已有用户并更新修改时间
+func (s *Store) modify(name string, fn func(u *User)) error {
+	return s.update(func() error {
+		u, ok := s.users[name]
+		if !ok {
+			return ErrNotFound
+		}
+		fn(u)
+		u.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// update 读取文件的最新内容后执行修改并写回，修改失败时恢复原来的内容
+func (s *Store) update(fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	if err := s.save(); err != nil {
+		// 丢弃内存中的修改
+		s.modTime = time.Time{}
+		s.load()
+		return err
+	}
+	return nil
+}
+
+// load 文件的修改时间变化时重新读取，调用方需持有写锁
+func (s *Store) load() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.users = map[string]*User{}
+		s.verified = map[string][]byte{}
+		s.modTime = time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var list []*User
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	users := make(map[string]*User, len(list))
+	for _, u := range list {
+		users[u.Name] = u
+	}
+	s.users = users
+	s.verified = map[string][]byte{}
+	s.modTime = info.ModTime()
+	return nil
+}
+
+// save 先写临时文件再重命名，避免其他进程读到写了一半的文件，调用方需持有写锁
+func (s *Store) save() error {
+	list := make([]*User, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.modTime = info.ModTime()
+	s.verified = map[string][]byte{}
+	return nil
+}
+
+func (s *Store) hash(password string) (string, error) {
+	if len(password) < MinPasswordLength || len(password) > maxPasswordLength {
+		return "", ErrWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (s *Store) mac(hash, password string) []byte {
+	m := hmac.New(sha256.New, s.key)
+	m.Write([]byte(hash))
+	m.Write([]byte{0})
+	m.Write([]byte(password))
+	return m.Sum(nil)
+}
+
+// Reserved 是否为保留的名称
+func Reserved(name string) bool {
+	return reservedNames[strings.ToLower(name)]
+}
+
+// validName 用户名会出现在BasicAuth和日志中，不允许冒号、空白和控制字符，也不允许保留的名称
+func validName(name string) bool {
+	if name == "" || len(name) > maxNameLength || strings.Contains(name, ":") || Reserved(name) {
+		return false
+	}
+	for _, r := range name {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || r == unicode.ReplacementChar {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,244 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound API key不存在
+	ErrNotFound = errors.New("api key not found")
+	// ErrInvalid API key格式错误、密钥不正确或已撤销
+	ErrInvalid = errors.New("invalid api key")
+	// ErrExpired API key已过期
+	ErrExpired = errors.New("api key is expired")
+)
+
+const (
+	// Prefix 所有API key的前缀，用于和JWT等其他令牌区分
+	Prefix = "hk_"
+	// idLength ID为Prefix加8位十六进制，明文显示，用于查找和识别
+	idLength = len(Prefix) + 8
+	// secretLength 密钥部分为32字节随机数的base64url编码
+	secretLength = 43
+	// touchInterval 最后使用时间至少间隔这么久才写入文件
+	touchInterval = time.Minute
+)
+
+// Key API key的元数据，不包含密钥
+type Key struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active 没有撤销也没有过期
+func (k *Key) Active() bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt))
+}
+
+// record 保存在文件中的API key，只保存完整key的SHA-256
+type record struct {
+	Key
+	Hash string `json:"hash"`
+}
+
+// IsKey 是否为API key格式，只检查前缀
+func IsKey(s string) bool {
+	return strings.HasPrefix(s, Prefix)
+}
+
+// Store API key存储，每次修改都整体重写JSON文件
+type Store struct {
+	path string
+
+	mu   sync.Mutex
+	keys map[string]*record
+	// saved 最后使用时间上次写入文件的时间
+	saved map[string]time.Time
+}
+
+// Open 打开API key文件
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, keys: map[string]*record{}, saved: map[string]time.Time{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*record
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, r := range list {
+		s.keys[r.ID] = r
+	}
+	return s, nil
+}
+
+// Create 生成API key，返回的完整key只有这一次能看到
+func (s *Store) Create(name string, scopes []string, expiresAt *time.Time, createdBy string) (string, *Key, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var id string
+	for {
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			return "", nil, err
+		}
+		id = Prefix + hex.EncodeToString(b)
+		if _, ok := s.keys[id]; !ok {
+			break
+		}
+	}
+	raw := id + "_" + base64.RawURLEncoding.EncodeToString(secret)
+
+	r := &record{
+		Key: Key{
+			ID:        id,
+			Name:      name,
+			Scopes:    append([]string{}, scopes...),
+			CreatedBy: createdBy,
+			CreatedAt: time.Now(),
+			ExpiresAt: expiresAt,
+		},
+		Hash: hash(raw),
+	}
+	s.keys[id] = r
+	if err := s.save(); err != nil {
+		delete(s.keys, id)
+		return "", nil, err
+	}
+	return raw, r.copy(), nil
+}
+
+// Authenticate 校验API key并记录最后使用时间
+func (s *Store) Authenticate(raw string) (*Key, error) {
+	if len(raw) != idLength+1+secretLength || !IsKey(raw) || raw[idLength] != '_' {
+		return nil, ErrInvalid
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.keys[raw[:idLength]]
+	if !ok || subtle.ConstantTimeCompare([]byte(r.Hash), []byte(hash(raw))) != 1 || r.RevokedAt != nil {
+		return nil, ErrInvalid
+	}
+	now := time.Now()
+	if r.ExpiresAt != nil && !now.Before(*r.ExpiresAt) {
+		return nil, ErrExpired
+	}
+
+	r.LastUsedAt = &now
+	if now.Sub(s.saved[r.ID]) > touchInterval {
+		// 最后使用时间只是参考信息，写入失败不影响认证
+		if s.save() == nil {
+			s.saved[r.ID] = now
+		}
+	}
+	return r.copy(), nil
+}
+
+// Get 按ID获取API key
+func (s *Store) Get(id string) (*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.keys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return r.copy(), nil
+}
+
+// List 按创建时间倒序列出全部API key，包括已撤销的
+func (s *Store) List() []*Key {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]*Key, 0, len(s.keys))
+	for _, r := range s.keys {
+		list = append(list, r.copy())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list
+}
+
+// Revoke 撤销API key，记录保留以便审计
+func (s *Store) Revoke(id string) (*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.keys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if r.RevokedAt == nil {
+		now := time.Now()
+		r.RevokedAt = &now
+		if err := s.save(); err != nil {
+			r.RevokedAt = nil
+			return nil, err
+		}
+	}
+	return r.copy(), nil
+}
+
+// save 先写临时文件再重命名，调用方需持有锁
+func (s *Store) save() error {
+	list := make([]*record, 0, len(s.keys))
+	for _, r := range s.keys {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (r *record) copy() *Key {
+	k := r.Key
+	k.Scopes = append([]string{}, r.Scopes...)
+	return &k
+}
+
+func hash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
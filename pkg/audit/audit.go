@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Entry 一条审计记录
+type Entry struct {
+	Time       time.Time `json:"time"`
+	User       string    `json:"user"`
+	Action     string    `json:"action"`
+	Permission string    `json:"permission,omitempty"`
+	Allowed    bool      `json:"allowed"`
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Client     string    `json:"client,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+}
+
+// Log 以JSON Lines格式追加写入的审计日志
+type Log struct {
+	mu   sync.Mutex
+	path string
+}
+
+// New 创建审计日志，文件在第一次写入时创建
+func New(path string) *Log {
+	return &Log{path: path}
+}
+
+// Record 追加一条审计记录，没有设置时间时使用当前时间
+func (l *Log) Record(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
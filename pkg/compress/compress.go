@@ -0,0 +1,137 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+)
+
+// Codec 压缩格式
+type Codec string
+
+const (
+	None  Codec = ""
+	Gzip  Codec = "gzip"
+	Zstd  Codec = "zstd"
+	Bzip2 Codec = "bzip2"
+)
+
+// 各压缩格式的魔数
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+)
+
+// ParseCodec 解析配置中的压缩格式名称
+func ParseCodec(name string) (Codec, error) {
+	switch Codec(name) {
+	case None, "none":
+		return None, nil
+	case Gzip, "gz":
+		return Gzip, nil
+	case Zstd, "zst":
+		return Zstd, nil
+	case Bzip2, "bz2":
+		return Bzip2, nil
+	}
+	return None, fmt.Errorf("unknown compression %q", name)
+}
+
+// ParseOutputCodec 解析输出使用的压缩格式，只接受支持写出的格式
+func ParseOutputCodec(name string) (Codec, error) {
+	codec, err := ParseCodec(name)
+	if err != nil {
+		return None, err
+	}
+	if !codec.Writable() {
+		return None, fmt.Errorf("compression %q is not supported for writing", name)
+	}
+	return codec, nil
+}
+
+// Writable 是否支持写出，bzip2只能读取
+func (c Codec) Writable() bool {
+	return c == None || c == Gzip || c == Zstd
+}
+
+// Ext 压缩格式对应的文件扩展名
+func (c Codec) Ext() string {
+	switch c {
+	case Gzip:
+		return ".gz"
+	case Zstd:
+		return ".zst"
+	case Bzip2:
+		return ".bz2"
+	}
+	return ""
+}
+
+// Detect 通过魔数识别压缩格式，不会消耗reader中的数据
+func Detect(r *bufio.Reader) (Codec, error) {
+	head, err := r.Peek(4)
+	if err != nil && err != io.EOF {
+		return None, err
+	}
+	switch {
+	case bytes.HasPrefix(head, zstdMagic):
+		return Zstd, nil
+	case bytes.HasPrefix(head, gzipMagic):
+		return Gzip, nil
+	case bytes.HasPrefix(head, bzip2Magic):
+		return Bzip2, nil
+	}
+	return None, nil
+}
+
+// NewReader 自动识别并解压输入流，未压缩的数据原样返回
+func NewReader(r io.Reader) (io.ReadCloser, Codec, error) {
+	br := bufio.NewReader(r)
+	codec, err := Detect(br)
+	if err != nil {
+		return nil, None, err
+	}
+
+	switch codec {
+	case Gzip:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, codec, err
+		}
+		return zr, codec, nil
+	case Zstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, codec, err
+		}
+		return zr.IOReadCloser(), codec, nil
+	case Bzip2:
+		return io.NopCloser(bzip2.NewReader(br)), codec, nil
+	}
+	return io.NopCloser(br), codec, nil
+}
+
+// NewWriter 按指定格式压缩输出流，关闭返回的writer时不会关闭w
+func NewWriter(w io.Writer, codec Codec) (io.WriteCloser, error) {
+	switch codec {
+	case None:
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("compression %q is not supported for writing", codec)
+}
+
+// nopWriteCloser 不压缩时的空实现
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
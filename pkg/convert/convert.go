@@ -0,0 +1,264 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format 支持转换的数据格式
+type Format string
+
+const (
+	CSV      Format = "csv"
+	JSON     Format = "json"
+	JSONL    Format = "jsonl"
+	YAML     Format = "yaml"
+	XML      Format = "xml"
+	Protobuf Format = "protobuf"
+)
+
+// contentTypes 各格式输出时使用的Content-Type，与gin的渲染器保持一致
+var contentTypes = map[Format]string{
+	CSV:      "text/csv; charset=utf-8",
+	JSON:     "application/json; charset=utf-8",
+	JSONL:    "application/x-ndjson; charset=utf-8",
+	YAML:     "application/x-yaml; charset=utf-8",
+	XML:      "application/xml; charset=utf-8",
+	Protobuf: "application/x-protobuf",
+}
+
+// ParseFormat 解析格式名称
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case CSV, JSON, JSONL, YAML, XML, Protobuf:
+		return f, nil
+	case "ndjson":
+		return JSONL, nil
+	case "yml":
+		return YAML, nil
+	case "proto", "pb":
+		return Protobuf, nil
+	}
+	return "", fmt.Errorf("unsupported format %q", name)
+}
+
+// ContentType 格式对应的Content-Type
+func (f Format) ContentType() string {
+	return contentTypes[f]
+}
+
+// Record 格式无关的一条记录
+type Record map[string]interface{}
+
+// Reader 逐条读取记录，读完时返回io.EOF
+type Reader interface {
+	Read() (Record, error)
+}
+
+// Writer 逐条写出记录，Close时写出格式的结尾部分
+type Writer interface {
+	Write(rec Record) error
+	Close() error
+}
+
+// NewReader 创建指定格式的记录读取器
+func NewReader(f Format, r io.Reader, schema Schema) (Reader, error) {
+	switch f {
+	case CSV:
+		return newCSVReader(r, schema)
+	case JSON, JSONL:
+		return newJSONReader(r), nil
+	case YAML:
+		return newYAMLReader(r), nil
+	case XML:
+		return newXMLReader(r), nil
+	case Protobuf:
+		return newProtobufReader(r), nil
+	}
+	return nil, fmt.Errorf("unsupported input format %q", f)
+}
+
+// NewWriter 创建指定格式的记录写出器
+func NewWriter(f Format, w io.Writer, schema Schema) (Writer, error) {
+	switch f {
+	case CSV:
+		return newCSVWriter(w, schema), nil
+	case JSON:
+		return newJSONWriter(w, false), nil
+	case JSONL:
+		return newJSONWriter(w, true), nil
+	case YAML:
+		return newYAMLWriter(w), nil
+	case XML:
+		return newXMLWriter(w), nil
+	case Protobuf:
+		return newProtobufWriter(w), nil
+	}
+	return nil, fmt.Errorf("unsupported output format %q", f)
+}
+
+// Convert 流式地把r中的记录转换后写入w，返回转换的记录数
+func Convert(from, to Format, r io.Reader, w io.Writer, schema Schema) (int, error) {
+	reader, err := NewReader(from, r, schema)
+	if err != nil {
+		return 0, err
+	}
+	writer, err := NewWriter(to, w, schema)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, fmt.Errorf("record %d: %w", n+1, err)
+		}
+		if err := schema.Apply(rec); err != nil {
+			return n, fmt.Errorf("record %d: %w", n+1, err)
+		}
+		if err := writer.Write(rec); err != nil {
+			return n, fmt.Errorf("record %d: %w", n+1, err)
+		}
+		n++
+	}
+	return n, writer.Close()
+}
+
+// Column 模式中的一列
+type Column struct {
+	Name string
+	Type string
+}
+
+// Schema 列的类型提示，形如 "id:int,name:string,price:float,active:bool"
+// CSV、XML这类只有字符串的格式依靠它还原类型，输出CSV时按它的顺序排列列
+type Schema []Column
+
+// ParseSchema 解析模式提示，未写类型的列按string处理
+func ParseSchema(s string) (Schema, error) {
+	var schema Schema
+	if strings.TrimSpace(s) == "" {
+		return schema, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		name, typ, _ := strings.Cut(strings.TrimSpace(part), ":")
+		if typ == "" {
+			typ = "string"
+		}
+		switch typ {
+		case "string", "int", "float", "bool":
+		default:
+			return nil, fmt.Errorf("column %s: unsupported type %q", name, typ)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("empty column name in schema %q", s)
+		}
+		schema = append(schema, Column{Name: name, Type: typ})
+	}
+	return schema, nil
+}
+
+// Names 模式中的列名
+func (s Schema) Names() []string {
+	names := make([]string, len(s))
+	for i, c := range s {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// Apply 按类型提示转换记录中的字符串值
+func (s Schema) Apply(rec Record) error {
+	for _, c := range s {
+		v, ok := rec[c.Name]
+		if !ok {
+			continue
+		}
+		typed, err := coerce(v, c.Type)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", c.Name, err)
+		}
+		rec[c.Name] = typed
+	}
+	return nil
+}
+
+// coerce 把字符串转换为目标类型，非字符串值原样返回
+func coerce(v interface{}, typ string) (interface{}, error) {
+	str, ok := v.(string)
+	if !ok {
+		return v, nil
+	}
+	switch typ {
+	case "int":
+		if str == "" {
+			return nil, nil
+		}
+		return strconv.ParseInt(str, 10, 64)
+	case "float":
+		if str == "" {
+			return nil, nil
+		}
+		return strconv.ParseFloat(str, 64)
+	case "bool":
+		if str == "" {
+			return nil, nil
+		}
+		return strconv.ParseBool(str)
+	}
+	return str, nil
+}
+
+// columns 没有模式时输出列的顺序，按字母排序保证结果稳定
+func columns(rec Record) []string {
+	keys := make([]string, 0, len(rec))
+	for k := range rec {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// scalar 把值格式化为文本，复合类型用JSON表示
+func scalar(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return t, nil
+	case bool, int, int32, int64, uint32, uint64, float32, float64, json.Number:
+		return fmt.Sprint(t), nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// toInt64 把任意数值或数值字符串转换为int64
+func toInt64(v interface{}) (int64, error) {
+	switch t := v.(type) {
+	case int:
+		return int64(t), nil
+	case int32:
+		return int64(t), nil
+	case int64:
+		return t, nil
+	case uint64:
+		return int64(t), nil
+	case float64:
+		if t != float64(int64(t)) {
+			return 0, fmt.Errorf("%v is not an integer", t)
+		}
+		return int64(t), nil
+	case string:
+		return strconv.ParseInt(t, 10, 64)
+	}
+	return 0, fmt.Errorf("cannot convert %T to integer", v)
+}
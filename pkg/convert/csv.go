@@ -0,0 +1,80 @@
+package convert
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+// csvReader 第一行为表头的CSV读取器
+type csvReader struct {
+	r      *csv.Reader
+	header []string
+}
+
+func newCSVReader(r io.Reader, schema Schema) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return &csvReader{r: cr}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &csvReader{r: cr, header: append([]string(nil), header...)}, nil
+}
+
+func (c *csvReader) Read() (Record, error) {
+	if c.header == nil {
+		return nil, io.EOF
+	}
+	row, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(row) != len(c.header) {
+		return nil, fmt.Errorf("expected %d columns, got %d", len(c.header), len(row))
+	}
+	rec := make(Record, len(row))
+	for i, name := range c.header {
+		rec[name] = row[i]
+	}
+	return rec, nil
+}
+
+// csvWriter 表头取自模式，没有模式时取第一条记录的字段
+type csvWriter struct {
+	w      *csv.Writer
+	header []string
+	row    []string
+}
+
+func newCSVWriter(w io.Writer, schema Schema) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w), header: schema.Names()}
+}
+
+func (c *csvWriter) Write(rec Record) error {
+	if c.row == nil {
+		if len(c.header) == 0 {
+			c.header = columns(rec)
+		}
+		if err := c.w.Write(c.header); err != nil {
+			return err
+		}
+		c.row = make([]string, len(c.header))
+	}
+	for i, name := range c.header {
+		v, err := scalar(rec[name])
+		if err != nil {
+			return err
+		}
+		c.row[i] = v
+	}
+	return c.w.Write(c.row)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
@@ -0,0 +1,133 @@
+package convert
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// jsonReader 支持顶层为数组或多个连续对象（JSON Lines）的输入
+type jsonReader struct {
+	br      *bufio.Reader
+	dec     *json.Decoder
+	inArray bool
+}
+
+func newJSONReader(r io.Reader) *jsonReader {
+	return &jsonReader{br: bufio.NewReader(r)}
+}
+
+func (j *jsonReader) Read() (Record, error) {
+	if j.dec == nil {
+		if err := j.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	if j.inArray && !j.dec.More() {
+		return nil, io.EOF
+	}
+
+	var rec Record
+	if err := j.dec.Decode(&rec); err != nil {
+		return nil, err
+	}
+	for k, v := range rec {
+		rec[k] = normalizeNumber(v)
+	}
+	return rec, nil
+}
+
+// start 根据第一个非空白字符判断输入是否为数组，是数组时消耗掉开头的'['
+func (j *jsonReader) start() error {
+	for {
+		b, err := j.br.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		j.br.UnreadByte()
+		j.dec = json.NewDecoder(j.br)
+		j.dec.UseNumber()
+		if b == '[' {
+			// 通过Token消耗'['，让Decoder记住处于数组中以便正确处理逗号
+			if _, err := j.dec.Token(); err != nil {
+				return err
+			}
+			j.inArray = true
+		}
+		return nil
+	}
+}
+
+// normalizeNumber 把json.Number转换为int64或float64
+func normalizeNumber(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		if f, err := t.Float64(); err == nil {
+			return f
+		}
+		return t.String()
+	case []interface{}:
+		for i := range t {
+			t[i] = normalizeNumber(t[i])
+		}
+	case map[string]interface{}:
+		for k := range t {
+			t[k] = normalizeNumber(t[k])
+		}
+	}
+	return v
+}
+
+// jsonWriter 输出JSON数组或JSON Lines
+type jsonWriter struct {
+	w     *bufio.Writer
+	lines bool
+	count int
+}
+
+func newJSONWriter(w io.Writer, lines bool) *jsonWriter {
+	return &jsonWriter{w: bufio.NewWriter(w), lines: lines}
+}
+
+func (j *jsonWriter) Write(rec Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	switch {
+	case j.lines:
+	case j.count == 0:
+		j.w.WriteString("[\n")
+	default:
+		j.w.WriteString(",\n")
+	}
+	j.w.Write(b)
+	if j.lines {
+		j.w.WriteByte('\n')
+	}
+	j.count++
+	// 每条记录都刷新，保证输出是流式的
+	return j.w.Flush()
+}
+
+func (j *jsonWriter) Close() error {
+	if !j.lines {
+		if j.count == 0 {
+			j.w.WriteString("[")
+		}
+		j.w.WriteString("\n]\n")
+	}
+	if err := j.w.Flush(); err != nil {
+		return fmt.Errorf("flush json: %w", err)
+	}
+	return nil
+}
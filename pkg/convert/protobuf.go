@@ -0,0 +1,126 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/qinchy/hellogo/gin/proto"
+	protobuf "google.golang.org/protobuf/proto"
+	"io"
+	"math"
+)
+
+// maxMessageSize 单条protobuf消息的最大长度
+const maxMessageSize = 64 << 20
+
+// protobufReader 读取以varint长度为前缀的proto.Test消息流
+type protobufReader struct {
+	r   *bufio.Reader
+	buf bytes.Buffer
+}
+
+func newProtobufReader(r io.Reader) *protobufReader {
+	return &protobufReader{r: bufio.NewReader(r)}
+}
+
+func (p *protobufReader) Read() (Record, error) {
+	size, err := binary.ReadUvarint(p.r)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxMessageSize {
+		return nil, fmt.Errorf("message size %d exceeds limit", size)
+	}
+	// 长度前缀不可信，按实际读到的数据增长缓冲，不预先分配声明的长度
+	p.buf.Reset()
+	if _, err := io.CopyN(&p.buf, p.r, int64(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	var msg proto.Test
+	if err := protobuf.Unmarshal(p.buf.Bytes(), &msg); err != nil {
+		return nil, err
+	}
+	reps := make([]interface{}, len(msg.Reps))
+	for i, v := range msg.Reps {
+		reps[i] = v
+	}
+	return Record{
+		"label": msg.GetLabel(),
+		"type":  int64(msg.GetType()),
+		"reps":  reps,
+	}, nil
+}
+
+// protobufWriter 把记录的label、type、reps字段写成带长度前缀的proto.Test消息
+type protobufWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func newProtobufWriter(w io.Writer) *protobufWriter {
+	return &protobufWriter{w: w}
+}
+
+func (p *protobufWriter) Write(rec Record) error {
+	msg, err := toTest(rec)
+	if err != nil {
+		return err
+	}
+	data, err := protobuf.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	p.buf = binary.AppendUvarint(p.buf[:0], uint64(len(data)))
+	p.buf = append(p.buf, data...)
+	_, err = p.w.Write(p.buf)
+	return err
+}
+
+func (p *protobufWriter) Close() error {
+	return nil
+}
+
+// toTest 记录转换为proto.Test
+func toTest(rec Record) (*proto.Test, error) {
+	label, err := scalar(rec["label"])
+	if err != nil {
+		return nil, fmt.Errorf("label: %w", err)
+	}
+	msg := &proto.Test{Label: &label}
+
+	if v, ok := rec["type"]; ok && v != nil && v != "" {
+		t, err := toInt64(v)
+		if err != nil {
+			return nil, fmt.Errorf("type: %w", err)
+		}
+		if t < math.MinInt32 || t > math.MaxInt32 {
+			return nil, fmt.Errorf("type: %d out of int32 range", t)
+		}
+		t32 := int32(t)
+		msg.Type = &t32
+	}
+
+	switch reps := rec["reps"].(type) {
+	case nil:
+	case []interface{}:
+		for _, v := range reps {
+			n, err := toInt64(v)
+			if err != nil {
+				return nil, fmt.Errorf("reps: %w", err)
+			}
+			msg.Reps = append(msg.Reps, n)
+		}
+	default:
+		n, err := toInt64(reps)
+		if err != nil {
+			return nil, fmt.Errorf("reps: %w", err)
+		}
+		msg.Reps = []int64{n}
+	}
+	return msg, nil
+}
@@ -0,0 +1,141 @@
+package convert
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// xmlReader 根元素下的每个子元素是一条记录，孙元素是字段，重复的字段合并为数组
+type xmlReader struct {
+	dec   *xml.Decoder
+	depth int
+}
+
+func newXMLReader(r io.Reader) *xmlReader {
+	return &xmlReader{dec: xml.NewDecoder(r)}
+}
+
+func (x *xmlReader) Read() (Record, error) {
+	var (
+		rec   Record
+		field string
+		text  strings.Builder
+	)
+	for {
+		tok, err := x.dec.Token()
+		if err == io.EOF && x.depth != 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			x.depth++
+			switch x.depth {
+			case 2:
+				rec = Record{}
+			case 3:
+				field = t.Name.Local
+				text.Reset()
+			}
+		case xml.CharData:
+			if x.depth >= 3 {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			x.depth--
+			switch x.depth {
+			case 1:
+				return rec, nil
+			case 2:
+				addField(rec, field, text.String())
+			}
+		}
+	}
+}
+
+// addField 添加字段，同名字段出现多次时转为数组
+func addField(rec Record, name, value string) {
+	old, ok := rec[name]
+	if !ok {
+		rec[name] = value
+		return
+	}
+	if list, ok := old.([]interface{}); ok {
+		rec[name] = append(list, value)
+		return
+	}
+	rec[name] = []interface{}{old, value}
+}
+
+// xmlWriter 输出 <records><record>...</record></records>
+type xmlWriter struct {
+	w     *bufio.Writer
+	count int
+}
+
+func newXMLWriter(w io.Writer) *xmlWriter {
+	return &xmlWriter{w: bufio.NewWriter(w)}
+}
+
+func (x *xmlWriter) Write(rec Record) error {
+	if x.count == 0 {
+		x.w.WriteString(xml.Header)
+		x.w.WriteString("<records>\n")
+	}
+	x.count++
+
+	x.w.WriteString("  <record>")
+	for _, name := range columns(rec) {
+		if !validXMLName(name) {
+			return fmt.Errorf("field %q is not a valid XML element name", name)
+		}
+		values, ok := rec[name].([]interface{})
+		if !ok {
+			values = []interface{}{rec[name]}
+		}
+		for _, v := range values {
+			text, err := scalar(v)
+			if err != nil {
+				return err
+			}
+			x.w.WriteString("<" + name + ">")
+			if err := xml.EscapeText(x.w, []byte(text)); err != nil {
+				return err
+			}
+			x.w.WriteString("</" + name + ">")
+		}
+	}
+	x.w.WriteString("</record>\n")
+	return x.w.Flush()
+}
+
+func (x *xmlWriter) Close() error {
+	if x.count == 0 {
+		x.w.WriteString(xml.Header)
+		x.w.WriteString("<records>\n")
+	}
+	x.w.WriteString("</records>\n")
+	return x.w.Flush()
+}
+
+// validXMLName 简单校验元素名：字母或下划线开头，后续为字母、数字、'-'、'_'、'.'
+func validXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && (r == '-' || r == '.' || r >= '0' && r <= '9'):
+		default:
+			return false
+		}
+	}
+	return true
+}
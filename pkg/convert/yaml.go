@@ -0,0 +1,69 @@
+package convert
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+)
+
+// yamlReader 每个文档可以是一个映射（一条记录）或映射的序列（多条记录）
+type yamlReader struct {
+	dec     *yaml.Decoder
+	pending []interface{}
+}
+
+func newYAMLReader(r io.Reader) *yamlReader {
+	return &yamlReader{dec: yaml.NewDecoder(r)}
+}
+
+func (y *yamlReader) Read() (Record, error) {
+	for len(y.pending) == 0 {
+		var doc interface{}
+		if err := y.dec.Decode(&doc); err != nil {
+			return nil, err
+		}
+		switch t := doc.(type) {
+		case nil:
+		case []interface{}:
+			y.pending = t
+		default:
+			y.pending = []interface{}{t}
+		}
+	}
+
+	item := y.pending[0]
+	y.pending = y.pending[1:]
+	m, ok := item.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected mapping, got %T", item)
+	}
+	return Record(m), nil
+}
+
+// yamlWriter 输出一个记录序列，每条记录单独序列化后追加
+type yamlWriter struct {
+	w     io.Writer
+	count int
+}
+
+func newYAMLWriter(w io.Writer) *yamlWriter {
+	return &yamlWriter{w: w}
+}
+
+func (y *yamlWriter) Write(rec Record) error {
+	b, err := yaml.Marshal([]map[string]interface{}{rec})
+	if err != nil {
+		return err
+	}
+	y.count++
+	_, err = y.w.Write(b)
+	return err
+}
+
+func (y *yamlWriter) Close() error {
+	if y.count == 0 {
+		_, err := io.WriteString(y.w, "[]\n")
+		return err
+	}
+	return nil
+}
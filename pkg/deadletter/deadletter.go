@@ -0,0 +1,238 @@
+package deadletter
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrNotFound 死信不存在
+var ErrNotFound = errors.New("dead letter not found")
+
+// Entry 一条死信记录
+type Entry struct {
+	ID        string    `json:"id"`
+	Reason    string    `json:"reason"`
+	Source    string    `json:"source"`
+	Line      int       `json:"line"`
+	Raw       string    `json:"raw"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store 以JSON Lines格式保存死信的文件存储
+type Store struct {
+	mu   sync.Mutex
+	path string
+}
+
+// New 创建死信存储，文件在第一次写入时创建
+func New(path string) *Store {
+	return &Store{path: path}
+}
+
+// AddAll 追加一批死信，跳过来源、行号和内容都与已有死信相同的记录，
+// 同一个文件重新读取时不会重复写入。返回实际追加的条数
+func (s *Store) AddAll(entries []Entry) (int, error) {
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+	seen := make(map[string]bool, len(existing)+len(entries))
+	for _, e := range existing {
+		seen[e.key()] = true
+	}
+
+	var buf []byte
+	added := 0
+	now := time.Now()
+	for _, e := range entries {
+		if seen[e.key()] {
+			continue
+		}
+		seen[e.key()] = true
+		e.ID = newID()
+		e.CreatedAt = now
+		e.UpdatedAt = now
+		line, err := json.Marshal(e)
+		if err != nil {
+			return 0, err
+		}
+		buf = append(append(buf, line...), '\n')
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	// 一次写入，避免失败时只留下一部分
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return 0, err
+	}
+	return added, f.Close()
+}
+
+// key 判断重复死信的依据
+func (e *Entry) key() string {
+	return e.Source + "\x00" + strconv.Itoa(e.Line) + "\x00" + e.Raw
+}
+
+// List 返回全部死信
+func (s *Store) List() ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Get 按ID查询死信
+func (s *Store) Get(id string) (Entry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, e := range entries {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return Entry{}, ErrNotFound
+}
+
+// Update 更新一条已存在的死信
+func (s *Store) Update(e Entry) error {
+	return s.rewrite(func(entries []Entry) ([]Entry, bool) {
+		for i := range entries {
+			if entries[i].ID == e.ID {
+				e.UpdatedAt = time.Now()
+				entries[i] = e
+				return entries, true
+			}
+		}
+		return entries, false
+	})
+}
+
+// Remove 删除一条死信
+func (s *Store) Remove(id string) error {
+	return s.rewrite(func(entries []Entry) ([]Entry, bool) {
+		for i := range entries {
+			if entries[i].ID == id {
+				return append(entries[:i], entries[i+1:]...), true
+			}
+		}
+		return entries, false
+	})
+}
+
+// RemoveAll 删除一批死信，不存在的ID直接忽略
+func (s *Store) RemoveAll(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	remove := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		remove[id] = true
+	}
+	return s.rewrite(func(entries []Entry) ([]Entry, bool) {
+		kept := entries[:0]
+		for _, e := range entries {
+			if !remove[e.ID] {
+				kept = append(kept, e)
+			}
+		}
+		return kept, true
+	})
+}
+
+// load 读取文件中的全部死信，调用方需持有锁
+func (s *Store) load() ([]Entry, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// rewrite 修改死信列表后通过临时文件整体替换
+func (s *Store) rewrite(modify func([]Entry) ([]Entry, bool)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+	entries, found := modify(entries)
+	if !found {
+		return ErrNotFound
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// newID 生成随机ID
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
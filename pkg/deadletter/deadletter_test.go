@@ -0,0 +1,45 @@
+package deadletter
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAddAllSkipsDuplicates(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "deadletters.jsonl"))
+	batch := []Entry{
+		{Reason: "parse", Source: "inbox/a.jsonl", Line: 2, Raw: "{"},
+		{Reason: "validate", Source: "inbox/a.jsonl", Line: 5, Raw: `{"id":0}`},
+	}
+	if n, err := s.AddAll(batch); err != nil || n != 2 {
+		t.Fatalf("AddAll = %d, %v", n, err)
+	}
+
+	// 同一文件重新读取，加上同名文件中内容不同的一行
+	again := append(batch, Entry{Reason: "parse", Source: "inbox/a.jsonl", Line: 2, Raw: "}"})
+	if n, err := s.AddAll(again); err != nil || n != 1 {
+		t.Fatalf("AddAll again = %d, %v, want 1", n, err)
+	}
+	entries, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("%d dead letters, want 3", len(entries))
+	}
+	ids := map[string]bool{}
+	for _, e := range entries {
+		if e.ID == "" || ids[e.ID] || e.CreatedAt.IsZero() {
+			t.Fatalf("bad entry %+v", e)
+		}
+		ids[e.ID] = true
+	}
+
+	// 删除后同样的记录可以再次写入
+	if err := s.Remove(entries[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.AddAll(batch[:1]); err != nil || n != 1 {
+		t.Fatalf("AddAll after Remove = %d, %v, want 1", n, err)
+	}
+}
@@ -0,0 +1,404 @@
+package fetch
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 缓存命中情况，通过Response.Cache返回
+const (
+	CacheHit         = "HIT"
+	CacheMiss        = "MISS"
+	CacheRevalidated = "REVALIDATED"
+	CacheBypass      = "BYPASS"
+)
+
+// heuristicMaxAge 上游只给了Last-Modified时按启发式规则计算的最长新鲜时间
+const heuristicMaxAge = 24 * time.Hour
+
+// entry 缓存的一个响应，Header为上游的完整响应头，返回给客户端时再按上游配置过滤
+type entry struct {
+	URL     string
+	Header  http.Header
+	Body    []byte
+	Stored  time.Time
+	Expires time.Time
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.Body) + len(e.URL))
+}
+
+func (e *entry) fresh(now time.Time) bool {
+	return now.Before(e.Expires)
+}
+
+// Cache 上游资源的HTTP缓存：遵循上游的Cache-Control、Expires，过期后用ETag、Last-Modified向上游验证。
+// 内存中按大小淘汰最久未使用的响应，可选的磁盘层保存内存放不下的响应；
+// 同一地址并发的未命中合并成一次上游请求
+type Cache struct {
+	f         *Fetcher
+	mem       *lru
+	disk      *diskTier
+	maxObject int64
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight 一次进行中的上游请求，同一地址的其他请求等待它的结果
+type flight struct {
+	done chan struct{}
+	e    *entry
+	err  error
+}
+
+// NewCache 创建缓存，memBytes为内存容量；diskDir不为空时启用容量为diskBytes的磁盘层
+func NewCache(f *Fetcher, memBytes int64, diskDir string, diskBytes int64) (*Cache, error) {
+	c := &Cache{
+		f:         f,
+		mem:       newLRU(memBytes),
+		maxObject: memBytes / 8,
+		flights:   map[string]*flight{},
+	}
+	if diskDir != "" {
+		disk, err := newDiskTier(diskDir, diskBytes)
+		if err != nil {
+			return nil, err
+		}
+		c.disk = disk
+		// 磁盘层可以保存比内存中更大的响应
+		if diskBytes/8 > c.maxObject {
+			c.maxObject = diskBytes / 8
+		}
+	}
+	return c, nil
+}
+
+// Fetch 优先从缓存返回响应。带Range或Cache-Control: no-store的请求不使用缓存；
+// 带no-cache或max-age=0的请求即使缓存仍然新鲜，也先向上游验证并更新缓存；
+// 客户端带的If-None-Match、If-Modified-Since与缓存的响应匹配时返回304
+func (c *Cache) Fetch(ctx context.Context, target string, header http.Header) (*Response, error) {
+	up, err := c.f.Match(target)
+	if err != nil {
+		return nil, err
+	}
+	if header.Get("Range") != "" || noStore(header.Get("Cache-Control")) {
+		resp, err := c.f.Fetch(ctx, target, header)
+		if resp != nil {
+			resp.Cache = CacheBypass
+		}
+		return resp, err
+	}
+
+	if e := c.get(target); e != nil && e.fresh(time.Now()) && !revalidate(header.Get("Cache-Control")) {
+		return c.serve(up, e, header, CacheHit), nil
+	}
+
+	// 合并同一地址并发的未命中
+	c.mu.Lock()
+	if fl, ok := c.flights[target]; ok {
+		c.mu.Unlock()
+		select {
+		case <-fl.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		switch {
+		case fl.e != nil:
+			return c.serve(up, fl.e, header, CacheHit), nil
+		case fl.err != nil && !errors.Is(fl.err, context.Canceled):
+			return nil, fl.err
+		}
+		// 响应不能缓存或者发起请求的客户端已经断开，自己请求上游
+		resp, err := c.f.Fetch(ctx, target, header)
+		if resp != nil {
+			resp.Cache = CacheMiss
+		}
+		return resp, err
+	}
+	fl := &flight{done: make(chan struct{})}
+	c.flights[target] = fl
+	c.mu.Unlock()
+
+	resp, e, err := c.load(ctx, up, target, header)
+	fl.e, fl.err = e, err
+	c.mu.Lock()
+	delete(c.flights, target)
+	c.mu.Unlock()
+	close(fl.done)
+	return resp, err
+}
+
+// load 向上游请求或者验证过期的缓存，能缓存时返回缓存的条目
+func (c *Cache) load(ctx context.Context, up *Upstream, target string, header http.Header) (*Response, *entry, error) {
+	reqHeader := http.Header{}
+	copyHeaders(reqHeader, header, up.RequestHeaders)
+	// 缓存自己验证，不使用客户端的条件请求头，否则上游的304无法返回给其他客户端
+	reqHeader.Del("If-None-Match")
+	reqHeader.Del("If-Modified-Since")
+	reqHeader.Del("If-Range")
+	stale := c.get(target)
+	if stale != nil {
+		if etag := stale.Header.Get("ETag"); etag != "" {
+			reqHeader.Set("If-None-Match", etag)
+		}
+		if lm := stale.Header.Get("Last-Modified"); lm != "" {
+			reqHeader.Set("If-Modified-Since", lm)
+		}
+	}
+
+	resp, err := c.f.roundTrip(ctx, up, target, reqHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if resp.StatusCode == http.StatusNotModified && stale != nil {
+		resp.Body.Close()
+		// 用304中的头更新缓存的新鲜度
+		refreshed := *stale
+		refreshed.Header = stale.Header.Clone()
+		for _, name := range []string{"Cache-Control", "Expires", "Date", "ETag", "Last-Modified"} {
+			if v := resp.Header.Get(name); v != "" {
+				refreshed.Header.Set(name, v)
+			}
+		}
+		refreshed.Stored = now
+		refreshed.Expires = now.Add(freshness(refreshed.Header, now))
+		c.put(&refreshed)
+		return c.serve(up, &refreshed, header, CacheRevalidated), &refreshed, nil
+	}
+
+	if resp.StatusCode != http.StatusOK || !cacheable(resp.Header) || resp.ContentLength > c.maxObject {
+		out := newResponse(up, resp.StatusCode, resp.Header, resp.ContentLength, resp.Body)
+		out.Cache = CacheMiss
+		return out, nil, nil
+	}
+
+	// 长度未知时最多读取maxObject，超过则不缓存，已读的部分和剩下的内容一起返回
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, c.maxObject+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, nil, err
+	}
+	if int64(len(buf)) > c.maxObject {
+		out := newResponse(up, resp.StatusCode, resp.Header, resp.ContentLength, readCloser{
+			Reader: io.MultiReader(bytes.NewReader(buf), resp.Body),
+			Closer: resp.Body,
+		})
+		out.Cache = CacheMiss
+		return out, nil, nil
+	}
+	resp.Body.Close()
+
+	e := &entry{
+		URL:     target,
+		Header:  resp.Header.Clone(),
+		Body:    buf,
+		Stored:  now,
+		Expires: now.Add(freshness(resp.Header, now)),
+	}
+	c.put(e)
+	return c.serve(up, e, header, CacheMiss), e, nil
+}
+
+// serve 用缓存的条目生成响应
+func (c *Cache) serve(up *Upstream, e *entry, header http.Header, status string) *Response {
+	resp := newResponse(up, http.StatusOK, e.Header, int64(len(e.Body)), io.NopCloser(bytes.NewReader(e.Body)))
+	resp.Cache = status
+	resp.Header.Set("Age", strconv.Itoa(int(time.Since(e.Stored).Seconds())))
+	if notModified(header, e.Header) {
+		resp.Status = http.StatusNotModified
+		resp.ContentLength = 0
+		resp.Body = io.NopCloser(bytes.NewReader(nil))
+	}
+	return resp
+}
+
+func (c *Cache) get(key string) *entry {
+	if e := c.mem.get(key); e != nil {
+		return e
+	}
+	if c.disk == nil {
+		return nil
+	}
+	e := c.disk.get(key)
+	if e != nil && e.size() <= c.mem.capacity/8 {
+		c.mem.put(e)
+	}
+	return e
+}
+
+func (c *Cache) put(e *entry) {
+	if e.size() <= c.mem.capacity/8 {
+		c.mem.put(e)
+	}
+	if c.disk != nil {
+		c.disk.put(e)
+	}
+}
+
+// notModified 客户端的条件请求头是否与缓存的响应匹配
+func notModified(req, resp http.Header) bool {
+	if inm := req.Get("If-None-Match"); inm != "" {
+		etag := resp.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, v := range strings.Split(inm, ",") {
+			v = strings.TrimSpace(v)
+			if v == "*" || strings.TrimPrefix(v, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := req.Get("If-Modified-Since"); ims != "" {
+		since, err1 := http.ParseTime(ims)
+		modified, err2 := http.ParseTime(resp.Get("Last-Modified"))
+		return err1 == nil && err2 == nil && !modified.After(since)
+	}
+	return false
+}
+
+// cacheable 作为共享缓存，不保存no-store、private以及按任意请求头变化的响应
+func cacheable(h http.Header) bool {
+	cc := parseCacheControl(h.Get("Cache-Control"))
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	if _, ok := cc["private"]; ok {
+		return false
+	}
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" && !strings.EqualFold(name, "Accept-Encoding") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// noStore 客户端要求不使用缓存
+func noStore(cacheControl string) bool {
+	_, ok := parseCacheControl(cacheControl)["no-store"]
+	return ok
+}
+
+// revalidate 客户端要求使用缓存前先向上游验证
+func revalidate(cacheControl string) bool {
+	cc := parseCacheControl(cacheControl)
+	if _, ok := cc["no-cache"]; ok {
+		return true
+	}
+	return cc["max-age"] == "0"
+}
+
+// freshness 响应的新鲜时间：s-maxage、max-age、Expires，都没有时取距上次修改时间的10%，no-cache时为0
+func freshness(h http.Header, now time.Time) time.Duration {
+	cc := parseCacheControl(h.Get("Cache-Control"))
+	if _, ok := cc["no-cache"]; ok {
+		return 0
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[name]; ok {
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				return time.Duration(n) * time.Second
+			}
+			return 0
+		}
+	}
+
+	date := now
+	if d, err := http.ParseTime(h.Get("Date")); err == nil {
+		date = d
+	}
+	if v := h.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil || !expires.After(date) {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+	if lm, err := http.ParseTime(h.Get("Last-Modified")); err == nil && date.After(lm) {
+		ttl := date.Sub(lm) / 10
+		if ttl > heuristicMaxAge {
+			ttl = heuristicMaxAge
+		}
+		return ttl
+	}
+	return 0
+}
+
+// parseCacheControl 解析Cache-Control，指令名转为小写
+func parseCacheControl(v string) map[string]string {
+	cc := map[string]string{}
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(part, "=")
+		cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return cc
+}
+
+// lru 按字节数限制容量的内存缓存，淘汰最久未使用的条目
+type lru struct {
+	capacity int64
+
+	mu    sync.Mutex
+	size  int64
+	order *list.List
+	items map[string]*list.Element
+}
+
+func newLRU(capacity int64) *lru {
+	return &lru{capacity: capacity, order: list.New(), items: map[string]*list.Element{}}
+}
+
+func (l *lru) get(key string) *entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.items[key]
+	if !ok {
+		return nil
+	}
+	l.order.MoveToFront(el)
+	return el.Value.(*entry)
+}
+
+func (l *lru) put(e *entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if el, ok := l.items[e.URL]; ok {
+		l.size -= el.Value.(*entry).size()
+		l.order.Remove(el)
+	}
+	l.items[e.URL] = l.order.PushFront(e)
+	l.size += e.size()
+	for l.size > l.capacity && l.order.Len() > 0 {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		evicted := oldest.Value.(*entry)
+		delete(l.items, evicted.URL)
+		l.size -= evicted.size()
+	}
+}
+
+// readCloser 组合读取和关闭
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
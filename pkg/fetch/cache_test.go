@@ -0,0 +1,84 @@
+package fetch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCacheClientNoCacheRevalidates(t *testing.T) {
+	var requests, conditional int32
+	version := int32(1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		etag := `"v1"`
+		if atomic.LoadInt32(&version) == 2 {
+			etag = `"v2"`
+		}
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") != "" {
+			atomic.AddInt32(&conditional, 1)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		io.WriteString(w, etag)
+	}))
+	defer upstream.Close()
+	cache, err := NewCache(newTestFetcher(t, upstream, nil), 1<<20, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := upstream.URL + "/img/a.png"
+
+	fetch := func(cacheControl string) (string, string) {
+		t.Helper()
+		header := http.Header{}
+		if cacheControl != "" {
+			header.Set("Cache-Control", cacheControl)
+		}
+		resp, err := cache.Fetch(context.Background(), target, header)
+		if err != nil {
+			t.Fatalf("Fetch: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.Cache, string(body)
+	}
+
+	if status, _ := fetch(""); status != CacheMiss {
+		t.Fatalf("first fetch = %s, want MISS", status)
+	}
+	if status, _ := fetch(""); status != CacheHit {
+		t.Fatalf("second fetch = %s, want HIT", status)
+	}
+
+	// no-cache用缓存的ETag验证，上游没有变化时返回缓存的内容
+	if status, body := fetch("no-cache"); status != CacheRevalidated || body != `"v1"` {
+		t.Fatalf("no-cache fetch = %s %s, want REVALIDATED", status, body)
+	}
+	if n := atomic.LoadInt32(&conditional); n != 1 {
+		t.Fatalf("conditional requests = %d, want 1", n)
+	}
+
+	// 上游变化后max-age=0同样验证，取回新的内容并更新缓存
+	atomic.StoreInt32(&version, 2)
+	if status, body := fetch("max-age=0"); status != CacheMiss || body != `"v2"` {
+		t.Fatalf("max-age=0 fetch = %s %s, want MISS with the new content", status, body)
+	}
+	if status, body := fetch(""); status != CacheHit || body != `"v2"` {
+		t.Fatalf("fetch after update = %s %s, want HIT with the new content", status, body)
+	}
+
+	if status, _ := fetch("no-store"); status != CacheBypass {
+		t.Fatalf("no-store fetch = %s, want BYPASS", status)
+	}
+	if n := atomic.LoadInt32(&requests); n != 4 {
+		t.Fatalf("upstream requests = %d, want 4", n)
+	}
+}
@@ -0,0 +1,129 @@
+package fetch
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// diskTier 缓存的磁盘层，每个响应以gob编码保存为一个文件，文件名为地址的SHA-256。
+// 命中时更新文件的修改时间，超过容量时删除修改时间最早的文件
+type diskTier struct {
+	dir      string
+	capacity int64
+
+	mu   sync.Mutex
+	size int64
+}
+
+func newDiskTier(dir string, capacity int64) (*diskTier, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	d := &diskTier{dir: dir, capacity: capacity}
+	files, err := d.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		d.size += f.Size()
+	}
+	return d, nil
+}
+
+func (d *diskTier) get(key string) *entry {
+	path := d.path(key)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var e entry
+	if err := gob.NewDecoder(f).Decode(&e); err != nil || e.URL != key {
+		return nil
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return &e
+}
+
+func (d *diskTier) put(e *entry) {
+	if e.size() > d.capacity {
+		return
+	}
+	tmp, err := os.CreateTemp(d.dir, "tmp-")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	err = gob.NewEncoder(tmp).Encode(e)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	}
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	path := d.path(e.URL)
+	if old, err := os.Stat(path); err == nil {
+		d.size -= old.Size()
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return
+	}
+	d.size += info.Size()
+	if d.size > d.capacity {
+		d.evict()
+	}
+}
+
+// evict 删除最久没有使用的文件直到不超过容量，调用方需持有锁
+func (d *diskTier) evict() {
+	files, err := d.files()
+	if err != nil {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, f := range files {
+		if d.size <= d.capacity {
+			return
+		}
+		if os.Remove(filepath.Join(d.dir, f.Name())) == nil {
+			d.size -= f.Size()
+		}
+	}
+}
+
+// files 磁盘层中的缓存文件，不包括写入中的临时文件
+func (d *diskTier) files() ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]os.FileInfo, 0, len(entries))
+	for _, de := range entries {
+		if de.IsDir() || len(de.Name()) != sha256.Size*2 {
+			continue
+		}
+		if info, err := de.Info(); err == nil {
+			files = append(files, info)
+		}
+	}
+	return files, nil
+}
+
+func (d *diskTier) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:]))
+}
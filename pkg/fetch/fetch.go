@@ -0,0 +1,307 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotAllowed 目标地址不在白名单中
+	ErrNotAllowed = errors.New("upstream is not allowed")
+
+	// ErrTooLarge 上游响应超过大小限制
+	ErrTooLarge = errors.New("upstream response too large")
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	defaultMaxSize = 10 << 20
+)
+
+var (
+	// defaultRequestHeaders 默认转发给上游的请求头
+	defaultRequestHeaders = []string{"Accept", "Accept-Language", "If-None-Match", "If-Modified-Since", "Range", "If-Range"}
+
+	// defaultResponseHeaders 默认返回给客户端的响应头
+	defaultResponseHeaders = []string{"Content-Type", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified", "Cache-Control", "Expires"}
+
+	// hopHeaders 逐跳的头，无论怎么配置都不转发
+	hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+)
+
+// Upstream 允许访问的上游，目标地址需要与BaseURL的协议、主机相同并且路径以BaseURL的路径开头
+type Upstream struct {
+	Name    string        `yaml:"name"`
+	BaseURL string        `yaml:"base_url"`
+	Timeout time.Duration `yaml:"timeout"`
+	MaxSize int64         `yaml:"max_size"`
+	// RequestHeaders 从客户端转发给上游的请求头，为空时使用默认值
+	RequestHeaders []string `yaml:"request_headers"`
+	// ResponseHeaders 从上游返回给客户端的响应头，为空时使用默认值
+	ResponseHeaders []string `yaml:"response_headers"`
+
+	base *url.URL
+}
+
+// Config 代理配置，对应的yaml文件形如：
+//
+//	default_url: https://www.baidu.com/img/PCtm_d9c8750bed0b3c7d089fa7d55720d6cf.png
+//	upstreams:
+//	  - name: baidu
+//	    base_url: https://www.baidu.com/img/
+//	    timeout: 10s
+//	    max_size: 10485760
+type Config struct {
+	// DefaultURL 请求没有指定地址时获取的地址
+	DefaultURL string     `yaml:"default_url"`
+	Upstreams  []Upstream `yaml:"upstreams"`
+}
+
+// DefaultConfig 没有配置文件时的配置，只允许原来写死的图片地址
+func DefaultConfig() Config {
+	return Config{
+		DefaultURL: "https://www.baidu.com/img/PCtm_d9c8750bed0b3c7d089fa7d55720d6cf.png",
+		Upstreams: []Upstream{{
+			Name:    "baidu",
+			BaseURL: "https://www.baidu.com/img/",
+		}},
+	}
+}
+
+// LoadConfig 读取yaml配置文件，文件不存在时返回默认配置
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return DefaultConfig(), nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// StatusError 上游返回了错误状态码
+type StatusError struct {
+	Upstream string
+	Status   int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("upstream %s returned %d %s", e.Upstream, e.Status, http.StatusText(e.Status))
+}
+
+// Response 上游的响应，Header只包含允许返回的头，Body在超过大小限制时返回ErrTooLarge
+type Response struct {
+	Upstream      *Upstream
+	Status        int
+	Header        http.Header
+	ContentLength int64
+	Body          io.ReadCloser
+	// Cache 缓存的命中情况：HIT、MISS、REVALIDATED、BYPASS，没有使用缓存时为空
+	Cache string
+}
+
+// Fetcher 按白名单访问上游的客户端
+type Fetcher struct {
+	cfg    Config
+	client *http.Client
+}
+
+// New 创建Fetcher，client为nil时使用不带超时的默认Transport，超时由每个上游单独控制
+func New(cfg Config, client *http.Client) (*Fetcher, error) {
+	for i := range cfg.Upstreams {
+		u := &cfg.Upstreams[i]
+		base, err := url.Parse(u.BaseURL)
+		if err != nil || base.Host == "" || (base.Scheme != "http" && base.Scheme != "https") {
+			return nil, fmt.Errorf("invalid base_url %q for upstream %q", u.BaseURL, u.Name)
+		}
+		u.base = base
+		if u.Name == "" {
+			u.Name = base.Host
+		}
+		if u.Timeout <= 0 {
+			u.Timeout = defaultTimeout
+		}
+		if u.MaxSize <= 0 {
+			u.MaxSize = defaultMaxSize
+		}
+		if len(u.RequestHeaders) == 0 {
+			u.RequestHeaders = defaultRequestHeaders
+		}
+		if len(u.ResponseHeaders) == 0 {
+			u.ResponseHeaders = defaultResponseHeaders
+		}
+	}
+
+	f := &Fetcher{cfg: cfg}
+	if client == nil {
+		client = &http.Client{}
+	}
+	copied := *client
+	// 重定向的目标同样需要在白名单中
+	copied.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("stopped after 5 redirects")
+		}
+		if _, err := f.Match(req.URL.String()); err != nil {
+			return err
+		}
+		return nil
+	}
+	f.client = &copied
+	return f, nil
+}
+
+// DefaultURL 请求没有指定地址时获取的地址
+func (f *Fetcher) DefaultURL() string {
+	return f.cfg.DefaultURL
+}
+
+// Match 找到允许访问目标地址的上游
+func (f *Fetcher) Match(target string) (*Upstream, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.User != nil || u.Host == "" {
+		return nil, fmt.Errorf("%w: %s", ErrNotAllowed, target)
+	}
+	for i := range f.cfg.Upstreams {
+		up := &f.cfg.Upstreams[i]
+		if strings.EqualFold(u.Scheme, up.base.Scheme) && strings.EqualFold(u.Host, up.base.Host) && pathAllowed(u.Path, up.base.Path) {
+			return up, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotAllowed, target)
+}
+
+// Fetch 以GET请求访问目标地址，只转发上游允许的请求头。
+// 200、206、304原样返回，其他状态码返回StatusError；调用方需要关闭Body
+func (f *Fetcher) Fetch(ctx context.Context, target string, header http.Header) (*Response, error) {
+	up, err := f.Match(target)
+	if err != nil {
+		return nil, err
+	}
+	reqHeader := http.Header{}
+	copyHeaders(reqHeader, header, up.RequestHeaders)
+	resp, err := f.roundTrip(ctx, up, target, reqHeader)
+	if err != nil {
+		return nil, err
+	}
+	return newResponse(up, resp.StatusCode, resp.Header, resp.ContentLength, resp.Body), nil
+}
+
+// roundTrip 发出请求并检查状态码和长度，返回的Body限制了大小，关闭时释放超时的context
+func (f *Fetcher) roundTrip(ctx context.Context, up *Upstream, target string, header http.Header) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, up.Timeout)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header = header
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified:
+	default:
+		resp.Body.Close()
+		cancel()
+		return nil, &StatusError{Upstream: up.Name, Status: resp.StatusCode}
+	}
+	if resp.ContentLength > up.MaxSize {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrTooLarge, resp.ContentLength, up.MaxSize)
+	}
+	resp.Body = &body{r: resp.Body, max: up.MaxSize, cancel: cancel}
+	return resp, nil
+}
+
+// newResponse 只保留上游允许返回的响应头
+func newResponse(up *Upstream, status int, header http.Header, length int64, body io.ReadCloser) *Response {
+	resp := &Response{
+		Upstream:      up,
+		Status:        status,
+		Header:        http.Header{},
+		ContentLength: length,
+		Body:          body,
+	}
+	copyHeaders(resp.Header, header, up.ResponseHeaders)
+	return resp
+}
+
+// pathAllowed 路径以前缀开头，前缀不以/结尾时要求在路径分隔处匹配，避免/img匹配到/images
+func pathAllowed(path, prefix string) bool {
+	if prefix == "" || prefix == "/" {
+		return true
+	}
+	if strings.Contains(path, "/../") || strings.HasSuffix(path, "/..") {
+		return false
+	}
+	if strings.HasSuffix(prefix, "/") {
+		return strings.HasPrefix(path, prefix)
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// copyHeaders 复制名单中的头，跳过逐跳的头
+func copyHeaders(dst, src http.Header, names []string) {
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		if hopHeader(name) {
+			continue
+		}
+		for _, v := range src.Values(name) {
+			dst.Add(name, v)
+		}
+	}
+}
+
+func hopHeader(name string) bool {
+	for _, h := range hopHeaders {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// body 限制大小的响应体，关闭时释放超时的context
+type body struct {
+	r      io.ReadCloser
+	max    int64
+	n      int64
+	cancel context.CancelFunc
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if remaining := b.max - b.n + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if b.n > b.max {
+		// 超出限制的部分不返回
+		return n - int(b.n-b.max), fmt.Errorf("%w: limit %d", ErrTooLarge, b.max)
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	defer b.cancel()
+	return b.r.Close()
+}
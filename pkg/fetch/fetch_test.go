@@ -0,0 +1,212 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestFetcher 只允许访问上游的/img/路径
+func newTestFetcher(t *testing.T, upstream *httptest.Server, configure func(up *Upstream)) *Fetcher {
+	t.Helper()
+	up := Upstream{Name: "test", BaseURL: upstream.URL + "/img/"}
+	if configure != nil {
+		configure(&up)
+	}
+	f, err := New(Config{Upstreams: []Upstream{up}}, upstream.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestFetchAllowlist(t *testing.T) {
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if r.URL.Path == "/img/redirect" {
+			http.Redirect(w, r, "/private/secret", http.StatusFound)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("request to a host outside the allowlist: %s", r.URL)
+	}))
+	defer other.Close()
+	f := newTestFetcher(t, upstream, nil)
+
+	rejected := []string{
+		other.URL + "/img/a.png",
+		upstream.URL + "/private/a.png",
+		upstream.URL + "/images/a.png",
+		upstream.URL + "/img/../private/a.png",
+		strings.Replace(upstream.URL, "http://", "http://user:pass@", 1) + "/img/a.png",
+		"ftp://" + strings.TrimPrefix(upstream.URL, "http://") + "/img/a.png",
+		"/img/a.png",
+	}
+	for _, target := range rejected {
+		if _, err := f.Fetch(context.Background(), target, nil); !errors.Is(err, ErrNotAllowed) {
+			t.Errorf("Fetch(%s) err = %v, want ErrNotAllowed", target, err)
+		}
+	}
+	if n := atomic.LoadInt32(&hits); n != 0 {
+		t.Fatalf("upstream received %d requests for rejected targets", n)
+	}
+
+	resp, err := f.Fetch(context.Background(), upstream.URL+"/img/a.png", nil)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	resp.Body.Close()
+
+	// 重定向到白名单以外的路径同样被拒绝
+	if _, err := f.Fetch(context.Background(), upstream.URL+"/img/redirect", nil); !errors.Is(err, ErrNotAllowed) {
+		t.Fatalf("redirect err = %v, want ErrNotAllowed", err)
+	}
+}
+
+func TestFetchHeaderFiltering(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Set-Cookie", "session=upstream")
+		w.Header().Set("X-Internal", "secret")
+		w.Header().Set("X-Allowed", "yes")
+		io.WriteString(w, "png")
+	}))
+	defer upstream.Close()
+	f := newTestFetcher(t, upstream, func(up *Upstream) {
+		up.RequestHeaders = []string{"Accept", "X-Trace", "Connection", "Proxy-Authorization"}
+		up.ResponseHeaders = []string{"Content-Type", "ETag", "X-Allowed", "Transfer-Encoding"}
+	})
+
+	header := http.Header{}
+	header.Set("Accept", "image/png")
+	header.Set("X-Trace", "abc")
+	header.Set("Cookie", "session=client")
+	header.Set("Authorization", "Basic Zm9vOmJhcg==")
+	header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	header.Set("Connection", "X-Trace")
+	resp, err := f.Fetch(context.Background(), upstream.URL+"/img/a.png", header)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	resp.Body.Close()
+
+	if got.Get("Accept") != "image/png" || got.Get("X-Trace") != "abc" {
+		t.Errorf("allowed request headers not forwarded: %v", got)
+	}
+	for _, name := range []string{"Cookie", "Authorization", "Proxy-Authorization"} {
+		if got.Get(name) != "" {
+			t.Errorf("request header %s forwarded", name)
+		}
+	}
+
+	if resp.Header.Get("Content-Type") != "image/png" || resp.Header.Get("ETag") != `"v1"` || resp.Header.Get("X-Allowed") != "yes" {
+		t.Errorf("allowed response headers missing: %v", resp.Header)
+	}
+	for _, name := range []string{"Set-Cookie", "X-Internal", "Transfer-Encoding", "Date"} {
+		if resp.Header.Get(name) != "" {
+			t.Errorf("response header %s returned", name)
+		}
+	}
+}
+
+func TestFetchMaxSize(t *testing.T) {
+	const limit = 1000
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/img/declared":
+			w.Header().Set("Content-Length", "2000")
+			w.Write(make([]byte, 2000))
+		case "/img/chunked":
+			// 不声明长度，读取时才能发现超过限制
+			for i := 0; i < 4; i++ {
+				w.Write(make([]byte, 500))
+				w.(http.Flusher).Flush()
+			}
+		case "/img/exact":
+			w.Write(make([]byte, limit))
+		}
+	}))
+	defer upstream.Close()
+	f := newTestFetcher(t, upstream, func(up *Upstream) { up.MaxSize = limit })
+
+	if _, err := f.Fetch(context.Background(), upstream.URL+"/img/declared", nil); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("declared length err = %v, want ErrTooLarge", err)
+	}
+
+	resp, err := f.Fetch(context.Background(), upstream.URL+"/img/chunked", nil)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("chunked read err = %v, want ErrTooLarge", err)
+	}
+	if len(data) != limit {
+		t.Fatalf("read %d bytes before the cutoff, want %d", len(data), limit)
+	}
+
+	resp, err = f.Fetch(context.Background(), upstream.URL+"/img/exact", nil)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	data, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || len(data) != limit {
+		t.Fatalf("exact size read %d bytes, err = %v", len(data), err)
+	}
+}
+
+func TestFetchStreaming(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first")
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, "second")
+	}))
+	defer upstream.Close()
+	defer close(release)
+	f := newTestFetcher(t, upstream, nil)
+
+	resp, err := f.Fetch(context.Background(), upstream.URL+"/img/stream", nil)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// 上游还没有写完时已经可以读到第一部分
+	first := make(chan string, 1)
+	go func() {
+		buf := make([]byte, len("first"))
+		n, _ := io.ReadFull(resp.Body, buf)
+		first <- string(buf[:n])
+	}()
+	select {
+	case s := <-first:
+		if s != "first" {
+			t.Fatalf("first chunk = %q", s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("first chunk was buffered until the upstream finished")
+	}
+
+	release <- struct{}{}
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil || string(rest) != "second" {
+		t.Fatalf("rest = %q, err = %v", rest, err)
+	}
+}
@@ -0,0 +1,242 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMalformed 令牌格式错误
+	ErrMalformed = errors.New("malformed token")
+	// ErrUnknownKey 令牌头中的kid不在密钥集合中
+	ErrUnknownKey = errors.New("unknown signing key")
+	// ErrSignature 签名错误，或者算法与kid对应的密钥不一致
+	ErrSignature = errors.New("invalid token signature")
+	// ErrExpired 令牌已过期
+	ErrExpired = errors.New("token is expired")
+	// ErrNotYetValid 令牌还未生效
+	ErrNotYetValid = errors.New("token is not valid yet")
+	// ErrClaims iss或aud与配置不一致
+	ErrClaims = errors.New("invalid token claims")
+)
+
+// maxTokenSize 令牌的最大长度，超过时不做解码
+const maxTokenSize = 8 << 10
+
+var encoding = base64.RawURLEncoding
+
+// Audience aud可以是字符串或字符串数组，只有一个时编码为字符串
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Contains 是否包含指定的受众
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims 令牌中的声明，时间为Unix秒
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Expiry 过期时间
+func (c *Claims) Expiry() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// Options 签发和校验令牌的参数
+type Options struct {
+	Issuer   string
+	Audience string
+	// TTL 访问令牌的有效期
+	TTL time.Duration
+	// Leeway 校验exp和nbf时允许的时钟偏差
+	Leeway time.Duration
+}
+
+// Manager 用密钥集合签发和校验访问令牌
+type Manager struct {
+	keys *KeySet
+	opts Options
+	now  func() time.Time
+}
+
+// New 创建Manager
+func New(keys *KeySet, opts Options) *Manager {
+	if opts.TTL <= 0 {
+		opts.TTL = 15 * time.Minute
+	}
+	if opts.Leeway < 0 {
+		opts.Leeway = 0
+	}
+	return &Manager{keys: keys, opts: opts, now: time.Now}
+}
+
+// TTL 访问令牌的有效期
+func (m *Manager) TTL() time.Duration {
+	return m.opts.TTL
+}
+
+// Issue 为subject签发访问令牌
+func (m *Manager) Issue(subject string) (string, *Claims, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	now := m.now()
+	claims := &Claims{
+		Issuer:    m.opts.Issuer,
+		Subject:   subject,
+		ExpiresAt: now.Add(m.opts.TTL).Unix(),
+		NotBefore: now.Unix(),
+		IssuedAt:  now.Unix(),
+		ID:        hex.EncodeToString(id),
+	}
+	if m.opts.Audience != "" {
+		claims.Audience = Audience{m.opts.Audience}
+	}
+	token, err := m.Sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
+
+// Sign 用当前签名密钥对任意声明签名
+func (m *Manager) Sign(claims interface{}) (string, error) {
+	key := m.keys.SigningKey()
+	h, err := json.Marshal(header{Algorithm: key.Algorithm, Type: "JWT", KeyID: key.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := encoding.EncodeToString(h) + "." + encoding.EncodeToString(payload)
+	sig, err := key.sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + encoding.EncodeToString(sig), nil
+}
+
+// Verify 校验签名、有效期、iss和aud，返回令牌中的声明
+func (m *Manager) Verify(token string) (*Claims, error) {
+	var claims Claims
+	if err := m.Decode(token, &claims); err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(m.opts.Leeway)) {
+		return nil, ErrExpired
+	}
+	if claims.NotBefore != 0 && now.Add(m.opts.Leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, ErrNotYetValid
+	}
+	if m.opts.Issuer != "" && claims.Issuer != m.opts.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrClaims, claims.Issuer)
+	}
+	if m.opts.Audience != "" && !claims.Audience.Contains(m.opts.Audience) {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrClaims)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrClaims)
+	}
+	return &claims, nil
+}
+
+// Decode 只校验签名，把载荷解码到v中，有效期等由调用方检查
+func (m *Manager) Decode(token string, v interface{}) error {
+	return m.keys.Decode(token, v)
+}
+
+// Decode 用集合中的密钥校验签名，把载荷解码到v中
+func (ks *KeySet) Decode(token string, v interface{}) error {
+	if len(token) > maxTokenSize {
+		return ErrMalformed
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrMalformed
+	}
+	rawHeader, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrMalformed
+	}
+	var h header
+	if err := json.Unmarshal(rawHeader, &h); err != nil {
+		return ErrMalformed
+	}
+	key, ok := ks.keys[h.KeyID]
+	if !ok {
+		return ErrUnknownKey
+	}
+	// 以密钥配置的算法为准，不信任令牌头中的alg
+	if h.Algorithm != key.Algorithm {
+		return ErrSignature
+	}
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrMalformed
+	}
+	if !key.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return ErrSignature
+	}
+
+	payload, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrMalformed
+	}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
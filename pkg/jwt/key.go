@@ -0,0 +1,303 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+)
+
+// 支持的签名算法
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+const (
+	// minSecretSize HS256密钥的最小长度
+	minSecretSize = 32
+	// minRSABits RSA密钥的最小位数
+	minRSABits = 2048
+)
+
+// Key 一个签名或验证密钥，kid写在令牌头中，用于轮换时找到对应的密钥
+type Key struct {
+	ID        string
+	Algorithm string
+
+	secret  []byte
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// NewHMACKey 创建HS256密钥
+func NewHMACKey(kid string, secret []byte) (*Key, error) {
+	if kid == "" {
+		return nil, errors.New("jwt: key id is required")
+	}
+	if len(secret) < minSecretSize {
+		return nil, fmt.Errorf("jwt: key %s: HS256 secret must be at least %d bytes", kid, minSecretSize)
+	}
+	return &Key{ID: kid, Algorithm: HS256, secret: secret}, nil
+}
+
+// ParseKey 解析PEM格式的RS256或EdDSA密钥，私钥可以签名和验证，公钥只能验证
+func ParseKey(kid, alg string, data []byte) (*Key, error) {
+	if kid == "" {
+		return nil, errors.New("jwt: key id is required")
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("jwt: key %s: no PEM block found", kid)
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("jwt: key %s: unsupported PEM type %q", kid, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt: key %s: %w", kid, err)
+	}
+
+	k := &Key{ID: kid, Algorithm: alg}
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		k.private, k.public = key, &key.PublicKey
+	case *rsa.PublicKey:
+		k.public = key
+	case ed25519.PrivateKey:
+		k.private, k.public = key, key.Public()
+	case ed25519.PublicKey:
+		k.public = key
+	default:
+		return nil, fmt.Errorf("jwt: key %s: unsupported key type %T", kid, parsed)
+	}
+	if err := k.check(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// NewPublicKey 用RSA或Ed25519公钥创建只能验证的密钥，如从JWKS中解析出的公钥
+func NewPublicKey(kid, alg string, public crypto.PublicKey) (*Key, error) {
+	if kid == "" {
+		return nil, errors.New("jwt: key id is required")
+	}
+	switch public.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("jwt: key %s: unsupported key type %T", kid, public)
+	}
+	k := &Key{ID: kid, Algorithm: alg, public: public}
+	if err := k.check(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// check 算法必须和密钥类型一致，避免用公钥当作HMAC密钥之类的算法混淆
+func (k *Key) check() error {
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		if k.Algorithm != RS256 {
+			return fmt.Errorf("jwt: key %s: RSA key requires %s", k.ID, RS256)
+		}
+		if pub.N.BitLen() < minRSABits {
+			return fmt.Errorf("jwt: key %s: RSA key must be at least %d bits", k.ID, minRSABits)
+		}
+	case ed25519.PublicKey:
+		if k.Algorithm != EdDSA {
+			return fmt.Errorf("jwt: key %s: Ed25519 key requires %s", k.ID, EdDSA)
+		}
+	}
+	return nil
+}
+
+// CanSign 是否持有私钥或共享密钥
+func (k *Key) CanSign() bool {
+	return k.secret != nil || k.private != nil
+}
+
+func (k *Key) sign(input []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case HS256:
+		m := hmac.New(sha256.New, k.secret)
+		m.Write(input)
+		return m.Sum(nil), nil
+	case RS256:
+		digest := sha256.Sum256(input)
+		return rsa.SignPKCS1v15(rand.Reader, k.private.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+	case EdDSA:
+		return ed25519.Sign(k.private.(ed25519.PrivateKey), input), nil
+	}
+	return nil, fmt.Errorf("jwt: unsupported algorithm %q", k.Algorithm)
+}
+
+func (k *Key) verify(input, sig []byte) bool {
+	switch k.Algorithm {
+	case HS256:
+		m := hmac.New(sha256.New, k.secret)
+		m.Write(input)
+		return hmac.Equal(m.Sum(nil), sig)
+	case RS256:
+		digest := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(k.public.(*rsa.PublicKey), crypto.SHA256, digest[:], sig) == nil
+	case EdDSA:
+		return ed25519.Verify(k.public.(ed25519.PublicKey), input, sig)
+	}
+	return false
+}
+
+// KeySet 签名密钥和全部验证密钥。轮换时先加入新密钥并改为用它签名，
+// 旧密钥保留到用它签发的令牌全部过期后再删除
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewKeySet 创建密钥集合，signing为签名使用的kid
+func NewKeySet(signing string, keys ...*Key) (*KeySet, error) {
+	ks, err := NewVerifyKeySet(keys...)
+	if err != nil {
+		return nil, err
+	}
+	ks.signing = ks.keys[signing]
+	if ks.signing == nil {
+		return nil, fmt.Errorf("jwt: signing key %q not found", signing)
+	}
+	if !ks.signing.CanSign() {
+		return nil, fmt.Errorf("jwt: signing key %q has no private key", signing)
+	}
+	return ks, nil
+}
+
+// NewVerifyKeySet 创建只用于校验的密钥集合，如第三方签发的令牌
+func NewVerifyKeySet(keys ...*Key) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*Key, len(keys))}
+	for _, k := range keys {
+		if _, ok := ks.keys[k.ID]; ok {
+			return nil, fmt.Errorf("jwt: duplicate key id %q", k.ID)
+		}
+		ks.keys[k.ID] = k
+	}
+	return ks, nil
+}
+
+// SigningKey 当前签名使用的密钥
+func (ks *KeySet) SigningKey() *Key {
+	return ks.signing
+}
+
+// KeyConfig 密钥配置，HS256的密钥从环境变量或文件读取，RS256和EdDSA从PEM文件读取
+type KeyConfig struct {
+	ID        string `yaml:"kid"`
+	Algorithm string `yaml:"alg"`
+	// File PEM私钥或公钥文件，HS256时为原始密钥文件，相对路径相对于配置文件所在目录
+	File string `yaml:"file"`
+	// SecretEnv HS256密钥所在的环境变量
+	SecretEnv string `yaml:"secret_env"`
+}
+
+// KeysConfig 密钥配置文件，形如：
+//
+//	signing: 2026-10
+//	keys:
+//	  - kid: 2026-10
+//	    alg: EdDSA
+//	    file: jwt/2026-10.pem
+//	  - kid: 2026-07
+//	    alg: RS256
+//	    file: jwt/2026-07.pub.pem
+//	  - kid: legacy
+//	    alg: HS256
+//	    secret_env: HELLOGO_JWT_SECRET
+type KeysConfig struct {
+	Signing string      `yaml:"signing"`
+	Keys    []KeyConfig `yaml:"keys"`
+}
+
+// LoadKeys 从配置文件加载密钥，文件不存在时返回os.ErrNotExist
+func LoadKeys(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg KeysConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	keys := make([]*Key, 0, len(cfg.Keys))
+	for _, kc := range cfg.Keys {
+		k, err := loadKey(filepath.Dir(path), kc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, k)
+	}
+	ks, err := NewKeySet(cfg.Signing, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ks, nil
+}
+
+func loadKey(dir string, kc KeyConfig) (*Key, error) {
+	if kc.Algorithm == HS256 && kc.SecretEnv != "" {
+		return NewHMACKey(kc.ID, []byte(os.Getenv(kc.SecretEnv)))
+	}
+	if kc.File == "" {
+		return nil, fmt.Errorf("jwt: key %s: file is required", kc.ID)
+	}
+	file := kc.File
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		// 不包装错误，密钥文件缺失不能被当成配置文件不存在
+		return nil, fmt.Errorf("jwt: key %s: %s", kc.ID, err.Error())
+	}
+	switch kc.Algorithm {
+	case HS256:
+		return NewHMACKey(kc.ID, data)
+	case RS256, EdDSA:
+		return ParseKey(kc.ID, kc.Algorithm, data)
+	}
+	return nil, fmt.Errorf("jwt: key %s: unsupported algorithm %q", kc.ID, kc.Algorithm)
+}
+
+// EphemeralKeySet 随机生成HS256密钥，进程重启后之前签发的令牌全部失效
+func EphemeralKeySet() (*KeySet, error) {
+	secret := make([]byte, minSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(secret)
+	k, err := NewHMACKey("ephemeral-"+hex.EncodeToString(sum[:4]), secret)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySet(k.ID, k)
+}
@@ -0,0 +1,86 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Suffix 清单文件相对于数据文件的后缀
+const Suffix = ".manifest.json"
+
+// ErrChecksumMismatch 文件内容与清单不一致
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Manifest 输出文件的清单，供下游校验
+type Manifest struct {
+	File      string    `json:"file"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	Records   int       `json:"records"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PathFor 数据文件对应的清单文件路径
+func PathFor(file string) string {
+	return file + Suffix
+}
+
+// Write 写出数据文件对应的清单，先写临时文件再重命名，避免下游读到半个清单
+func Write(file string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := PathFor(file)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load 读取数据文件对应的清单，不存在时返回的错误满足os.IsNotExist
+func Load(file string) (*Manifest, error) {
+	data, err := os.ReadFile(PathFor(file))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// Verify 流式计算文件的SHA-256并与清单比对
+func Verify(file string, m *Manifest) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if m.File != "" && m.File != filepath.Base(file) {
+		return fmt.Errorf("%w: manifest is for %s", ErrChecksumMismatch, m.File)
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if size != m.Size {
+		return fmt.Errorf("%w: size %d, manifest %d", ErrChecksumMismatch, size, m.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != m.SHA256 {
+		return fmt.Errorf("%w: sha256 %s, manifest %s", ErrChecksumMismatch, sum, m.SHA256)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/qinchy/hellogo/pkg/jwt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksMaxAge JWKS缓存的最长时间
+	jwksMaxAge = time.Hour
+	// jwksMinRefresh 遇到未知kid时重新读取JWKS的最小间隔，避免伪造的kid导致频繁请求
+	jwksMinRefresh = time.Minute
+)
+
+// jwk JWKS中的一个公钥，只支持RSA和Ed25519
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+}
+
+// keySet 缓存认证服务器的JWKS，密钥轮换后遇到未知kid时重新读取
+type keySet struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    *jwt.KeySet
+	fetched time.Time
+}
+
+func newKeySet(url string, client *http.Client) *keySet {
+	return &keySet{url: url, client: client}
+}
+
+// decode 校验签名并解码令牌
+func (ks *keySet) decode(ctx context.Context, token string, v interface{}) error {
+	keys, err := ks.get(ctx, false)
+	if err != nil {
+		return err
+	}
+	err = keys.Decode(token, v)
+	if errors.Is(err, jwt.ErrUnknownKey) {
+		if keys, err = ks.get(ctx, true); err != nil {
+			return err
+		}
+		err = keys.Decode(token, v)
+	}
+	return err
+}
+
+// get 返回缓存的密钥，过期或force时重新读取。读取失败但有旧密钥时继续使用旧密钥
+func (ks *keySet) get(ctx context.Context, force bool) (*jwt.KeySet, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	age := time.Since(ks.fetched)
+	if ks.keys != nil && age < jwksMaxAge && (!force || age < jwksMinRefresh) {
+		return ks.keys, nil
+	}
+	keys, err := ks.fetch(ctx)
+	if err != nil {
+		if ks.keys != nil {
+			return ks.keys, nil
+		}
+		return nil, err
+	}
+	ks.keys, ks.fetched = keys, time.Now()
+	return keys, nil
+}
+
+func (ks *keySet) fetch(ctx context.Context) (*jwt.KeySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	status, err := doJSON(ks.client, req, &doc)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc: jwks returned %d", status)
+	}
+
+	keys := make([]*jwt.Key, 0, len(doc.Keys))
+	for _, k := range doc.Keys {
+		// 跳过加密用的密钥和不支持的类型，认证服务器通常会同时发布多种密钥
+		if k.Use == "enc" || k.Kid == "" {
+			continue
+		}
+		key, err := k.parse()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("oidc: jwks has no usable signing keys")
+	}
+	return jwt.NewVerifyKeySet(keys...)
+}
+
+// parse 转换为jwt.Key，alg为空时按密钥类型确定
+func (k *jwk) parse() (*jwt.Key, error) {
+	var pub crypto.PublicKey
+	alg := k.Alg
+	switch {
+	case k.Kty == "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("oidc: invalid RSA exponent")
+		}
+		pub = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if alg == "" {
+			alg = jwt.RS256
+		}
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("oidc: invalid Ed25519 key")
+		}
+		pub = ed25519.PublicKey(x)
+		if alg == "" {
+			alg = jwt.EdDSA
+		}
+	default:
+		return nil, fmt.Errorf("oidc: unsupported key type %q", k.Kty)
+	}
+	return jwt.NewPublicKey(k.Kid, alg, pub)
+}
@@ -0,0 +1,422 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/qinchy/hellogo/pkg/jwt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrIDToken ID令牌的签名、签发者、受众、有效期或nonce不正确
+	ErrIDToken = errors.New("invalid id token")
+	// ErrNoUsername ID令牌中没有配置的用户名声明
+	ErrNoUsername = errors.New("id token has no username claim")
+	// ErrState 回调的state与登录时保存的不一致
+	ErrState = errors.New("invalid login state")
+)
+
+const (
+	// maxResponseSize 认证服务器响应的大小上限
+	maxResponseSize = 1 << 20
+	// leeway 校验exp和iat时允许的时钟偏差
+	leeway = time.Minute
+)
+
+// Config OIDC客户端配置文件，形如：
+//
+//	issuer: https://idp.example.com/realms/hellogo
+//	client_id: hellogo
+//	client_secret_env: HELLOGO_OIDC_CLIENT_SECRET
+//	redirect_url: https://localhost/auth/oidc/callback
+//	scopes: [openid, profile, email]
+//	username_claim: preferred_username
+//	roles_claim: groups
+//	role_mapping:
+//	  hellogo-admins: [admin]
+//	  hellogo-ops: [operator]
+//	auto_create: true
+type Config struct {
+	// Issuer 认证服务器地址，从 <issuer>/.well-known/openid-configuration 读取端点。
+	// 需要与ID令牌的iss完全一致，包括结尾的"/"
+	Issuer   string `yaml:"issuer"`
+	ClientID string `yaml:"client_id"`
+	// ClientSecretEnv 客户端密钥所在的环境变量，为空时作为公开客户端，只靠PKCE保护授权码
+	ClientSecretEnv string   `yaml:"client_secret_env"`
+	RedirectURL     string   `yaml:"redirect_url"`
+	Scopes          []string `yaml:"scopes"`
+	// UsernameClaim 作为本地用户名的声明，默认preferred_username
+	UsernameClaim string `yaml:"username_claim"`
+	// RolesClaim 保存组或角色的声明，值为字符串或字符串数组
+	RolesClaim string `yaml:"roles_claim"`
+	// RoleMapping RolesClaim中的值对应的本地角色。配置后每次登录都用映射结果覆盖本地用户的角色
+	RoleMapping map[string][]string `yaml:"role_mapping"`
+	// AutoCreate 本地用户不存在时自动创建，否则拒绝登录
+	AutoCreate bool `yaml:"auto_create"`
+}
+
+// LoadConfig 从yaml文件加载配置，文件不存在时返回os.ErrNotExist
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// metadata 认证服务器发现文档中用到的字段
+type metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// Provider 一个OIDC认证服务器，使用授权码模式加PKCE登录。
+// 发现文档在第一次使用时读取，失败时下次再试，不影响服务启动
+type Provider struct {
+	cfg          Config
+	clientSecret string
+	client       *http.Client
+	now          func() time.Time
+
+	mu   sync.Mutex
+	meta *metadata
+	keys *keySet
+}
+
+// New 校验配置并创建Provider，client为nil时使用http.DefaultClient
+func New(cfg Config, client *http.Client) (*Provider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("oidc: issuer, client_id and redirect_url are required")
+	}
+	if _, err := url.ParseRequestURI(cfg.RedirectURL); err != nil {
+		return nil, fmt.Errorf("oidc: redirect_url: %w", err)
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	if !contains(cfg.Scopes, "openid") {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "preferred_username"
+	}
+	if len(cfg.RoleMapping) > 0 && cfg.RolesClaim == "" {
+		return nil, errors.New("oidc: roles_claim is required when role_mapping is set")
+	}
+	var secret string
+	if cfg.ClientSecretEnv != "" {
+		if secret = os.Getenv(cfg.ClientSecretEnv); secret == "" {
+			return nil, fmt.Errorf("oidc: client secret environment variable %s is empty", cfg.ClientSecretEnv)
+		}
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Provider{cfg: cfg, clientSecret: secret, client: client, now: time.Now}, nil
+}
+
+// AutoCreate 本地用户不存在时是否自动创建
+func (p *Provider) AutoCreate() bool {
+	return p.cfg.AutoCreate
+}
+
+// MapsRoles 是否由认证服务器决定本地用户的角色
+func (p *Provider) MapsRoles() bool {
+	return len(p.cfg.RoleMapping) > 0
+}
+
+// Login 一次登录需要保存到会话中的随机值，回调时用于校验
+type Login struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewLogin 生成state、nonce和PKCE的code_verifier
+func NewLogin() (*Login, error) {
+	var values [3]string
+	for i := range values {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return &Login{State: values[0], Nonce: values[1], Verifier: values[2]}, nil
+}
+
+// CheckState 校验回调中的state
+func (l *Login) CheckState(state string) error {
+	if l.State == "" || subtle.ConstantTimeCompare([]byte(state), []byte(l.State)) != 1 {
+		return ErrState
+	}
+	return nil
+}
+
+// challenge PKCE的S256 code_challenge
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL 跳转到认证服务器的登录地址
+func (p *Provider) AuthCodeURL(ctx context.Context, login *Login) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidc: authorization_endpoint: %w", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", login.State)
+	q.Set("nonce", login.Nonce)
+	q.Set("code_challenge", challenge(login.Verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// tokenResponse 令牌端点的响应
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange 用授权码和code_verifier换取ID令牌并校验
+func (p *Provider) Exchange(ctx context.Context, code string, login *Login) (*IDToken, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {login.Verifier},
+	}
+	if p.clientSecret == "" {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		// client_secret_basic要求先对客户端ID和密钥做表单编码
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.clientSecret))
+	}
+
+	var tr tokenResponse
+	status, err := doJSON(p.client, req, &tr)
+	if err != nil {
+		return nil, err
+	}
+	if tr.Error != "" {
+		return nil, fmt.Errorf("oidc: token endpoint returned %s: %s", tr.Error, tr.ErrorDescription)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc: token endpoint returned %d", status)
+	}
+	if tr.IDToken == "" {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+	return p.Verify(ctx, tr.IDToken, login.Nonce)
+}
+
+// IDToken 校验通过的ID令牌
+type IDToken struct {
+	jwt.Claims
+	Nonce           string `json:"nonce,omitempty"`
+	AuthorizedParty string `json:"azp,omitempty"`
+	// Raw 全部声明，用于按配置读取用户名和角色
+	Raw map[string]interface{} `json:"-"`
+}
+
+// Claim 字符串类型的声明，不存在或不是字符串时为空
+func (t *IDToken) Claim(name string) string {
+	s, _ := t.Raw[name].(string)
+	return s
+}
+
+// Strings 字符串或字符串数组类型的声明
+func (t *IDToken) Strings(name string) []string {
+	switch v := t.Raw[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// Verify 用JWKS校验ID令牌的签名，并检查iss、aud、azp、exp、iat和nonce
+func (p *Provider) Verify(ctx context.Context, raw, nonce string) (*IDToken, error) {
+	if _, err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err := p.keys.decode(ctx, raw, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrIDToken, err.Error())
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	t := &IDToken{Raw: claims}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrIDToken, err.Error())
+	}
+
+	now := p.now()
+	switch {
+	case t.Issuer != p.cfg.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrIDToken, t.Issuer)
+	case !t.Audience.Contains(p.cfg.ClientID):
+		return nil, fmt.Errorf("%w: unexpected audience", ErrIDToken)
+	case len(t.Audience) > 1 && t.AuthorizedParty != p.cfg.ClientID:
+		return nil, fmt.Errorf("%w: unexpected authorized party %q", ErrIDToken, t.AuthorizedParty)
+	case t.ExpiresAt == 0 || now.After(time.Unix(t.ExpiresAt, 0).Add(leeway)):
+		return nil, fmt.Errorf("%w: %s", ErrIDToken, jwt.ErrExpired.Error())
+	case t.IssuedAt == 0 || now.Add(leeway).Before(time.Unix(t.IssuedAt, 0)):
+		return nil, fmt.Errorf("%w: invalid iat", ErrIDToken)
+	case t.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrIDToken)
+	case subtle.ConstantTimeCompare([]byte(t.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrIDToken)
+	}
+	return t, nil
+}
+
+// Identity ID令牌对应的本地用户
+type Identity struct {
+	Name string
+	// Issuer、Subject 认证服务器中用户的唯一标识，本地用户按它们关联
+	Issuer  string
+	Subject string
+	// Roles 按role_mapping映射出的本地角色，没有配置映射时为nil
+	Roles []string
+}
+
+// Identity 按配置从ID令牌中取出本地用户名和角色
+func (p *Provider) Identity(t *IDToken) (*Identity, error) {
+	name := t.Claim(p.cfg.UsernameClaim)
+	if name == "" {
+		return nil, fmt.Errorf("%w: %s", ErrNoUsername, p.cfg.UsernameClaim)
+	}
+	id := &Identity{Name: name, Issuer: t.Issuer, Subject: t.Subject}
+	if !p.MapsRoles() {
+		return id, nil
+	}
+	seen := map[string]bool{}
+	id.Roles = []string{}
+	for _, group := range t.Strings(p.cfg.RolesClaim) {
+		for _, role := range p.cfg.RoleMapping[group] {
+			if !seen[role] {
+				seen[role] = true
+				id.Roles = append(id.Roles, role)
+			}
+		}
+	}
+	sort.Strings(id.Roles)
+	return id, nil
+}
+
+// discover 读取并缓存发现文档
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	// 只有拼接发现文档地址时去掉结尾的"/"，校验issuer时使用原样的配置
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var meta metadata
+	status, err := doJSON(p.client, req, &meta)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("oidc: discovery returned %d", status)
+	}
+	// 发现文档中的issuer必须和配置一致，防止被替换成其他认证服务器
+	if meta.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %q does not match %q", meta.Issuer, p.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is missing endpoints")
+	}
+	if len(meta.CodeChallengeMethods) > 0 && !contains(meta.CodeChallengeMethods, "S256") {
+		return nil, errors.New("oidc: provider does not support PKCE S256")
+	}
+	p.meta = &meta
+	p.keys = newKeySet(meta.JWKSURI, p.client)
+	return p.meta, nil
+}
+
+// doJSON 发送请求并解码JSON响应，返回状态码
+func doJSON(client *http.Client, req *http.Request, v interface{}) (int, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("oidc: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return 0, fmt.Errorf("oidc: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil && resp.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("oidc: %s: %w", req.URL.Path, err)
+	}
+	return resp.StatusCode, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,343 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/qinchy/hellogo/pkg/jwt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "hellogo"
+	testClientSecret = "s3cr%t"
+)
+
+// mockIdP 模拟认证服务器：发现文档、JWKS和授权码换取令牌。
+// authorize记录授权请求中的code_challenge和nonce，换取令牌时校验code_verifier
+type mockIdP struct {
+	t      *testing.T
+	srv    *httptest.Server
+	issuer string
+	signer *jwt.Manager
+	public ed25519.PublicKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+	// claims 修改签发的ID令牌
+	claims func(claims map[string]interface{})
+	// discoveryIssuer 发现文档中的issuer，为空时使用issuer
+	discoveryIssuer string
+	jwksRequests    int
+}
+
+type authorization struct {
+	challenge string
+	nonce     string
+}
+
+// newMockIdP 创建认证服务器，issuerPath为issuer在服务器上的路径，如"/"或"/realms/hellogo"
+func newMockIdP(t *testing.T, issuerPath string) *mockIdP {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwt.ParseKey("idp-1", jwt.EdDSA, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := jwt.NewKeySet("idp-1", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &mockIdP{t: t, signer: jwt.New(keys, jwt.Options{}), public: public, codes: map[string]authorization{}}
+	mux := http.NewServeMux()
+	base := strings.TrimSuffix(issuerPath, "/")
+	mux.HandleFunc(base+"/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/jwks", idp.jwks)
+	mux.HandleFunc("/token", idp.token)
+	idp.srv = httptest.NewServer(mux)
+	t.Cleanup(idp.srv.Close)
+	idp.issuer = idp.srv.URL + issuerPath
+	return idp
+}
+
+func (idp *mockIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := idp.issuer
+	if idp.discoveryIssuer != "" {
+		issuer = idp.discoveryIssuer
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                           issuer,
+		"authorization_endpoint":           idp.srv.URL + "/authorize",
+		"token_endpoint":                   idp.srv.URL + "/token",
+		"jwks_uri":                         idp.srv.URL + "/jwks",
+		"code_challenge_methods_supported": []string{"S256"},
+	})
+}
+
+func (idp *mockIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	idp.jwksRequests++
+	idp.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"kid": "idp-1",
+			"alg": "EdDSA",
+			"use": "sig",
+			"x":   base64.RawURLEncoding.EncodeToString(idp.public),
+		}},
+	})
+}
+
+// authorize 模拟用户在认证服务器登录，返回回调中的授权码
+func (idp *mockIdP) authorize(authURL string) string {
+	idp.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != testClientID || q.Get("code_challenge_method") != "S256" {
+		idp.t.Fatalf("unexpected authorization request %s", authURL)
+	}
+	if !strings.Contains(" "+q.Get("scope")+" ", " openid ") {
+		idp.t.Fatalf("scope %q does not contain openid", q.Get("scope"))
+	}
+	code := "code-" + q.Get("state")
+	idp.mu.Lock()
+	idp.codes[code] = authorization{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	idp.mu.Unlock()
+	return code
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	id, _ = url.QueryUnescape(id)
+	secret, _ = url.QueryUnescape(secret)
+	if id != testClientID || secret != testClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+	r.ParseForm()
+	idp.mu.Lock()
+	auth, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" || challenge(r.PostForm.Get("code_verifier")) != auth.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":                idp.issuer,
+		"sub":                "248289761001",
+		"aud":                testClientID,
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              auth.nonce,
+		"preferred_username": "alice",
+		"groups":             []string{"hellogo-ops", "hellogo-admins", "other"},
+	}
+	if idp.claims != nil {
+		idp.claims(claims)
+	}
+	raw, err := idp.signer.Sign(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": raw, "access_token": "at", "token_type": "Bearer"})
+}
+
+func (idp *mockIdP) provider(t *testing.T, issuer string) *Provider {
+	t.Helper()
+	t.Setenv("HELLOGO_TEST_OIDC_SECRET", testClientSecret)
+	p, err := New(Config{
+		Issuer:          issuer,
+		ClientID:        testClientID,
+		ClientSecretEnv: "HELLOGO_TEST_OIDC_SECRET",
+		RedirectURL:     "https://localhost/auth/oidc/callback",
+		RolesClaim:      "groups",
+		RoleMapping: map[string][]string{
+			"hellogo-admins": {"admin", "operator"},
+			"hellogo-ops":    {"operator"},
+		},
+	}, idp.srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// login 走一遍授权码流程，返回换取并校验后的ID令牌
+func login(t *testing.T, idp *mockIdP, p *Provider) (*IDToken, error) {
+	t.Helper()
+	l, err := NewLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := p.AuthCodeURL(context.Background(), l)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code := idp.authorize(authURL)
+	return p.Exchange(context.Background(), code, l)
+}
+
+func TestLoginFlowAndRoleMapping(t *testing.T) {
+	idp := newMockIdP(t, "/realms/hellogo")
+	p := idp.provider(t, idp.issuer)
+
+	token, err := login(t, idp, p)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	identity, err := p.Identity(token)
+	if err != nil {
+		t.Fatalf("Identity: %v", err)
+	}
+	want := &Identity{Name: "alice", Issuer: idp.issuer, Subject: "248289761001", Roles: []string{"admin", "operator"}}
+	if !reflect.DeepEqual(identity, want) {
+		t.Fatalf("identity = %+v, want %+v", identity, want)
+	}
+
+	// 没有映射到任何角色的组清空本地角色
+	idp.claims = func(claims map[string]interface{}) { claims["groups"] = "other" }
+	token, err = login(t, idp, p)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if identity, _ := p.Identity(token); identity.Roles == nil || len(identity.Roles) != 0 {
+		t.Fatalf("roles = %#v, want empty", identity.Roles)
+	}
+
+	idp.claims = func(claims map[string]interface{}) { delete(claims, "preferred_username") }
+	token, err = login(t, idp, p)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if _, err := p.Identity(token); !errors.Is(err, ErrNoUsername) {
+		t.Fatalf("Identity err = %v, want ErrNoUsername", err)
+	}
+
+	// JWKS缓存后只读取一次
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	if idp.jwksRequests != 1 {
+		t.Fatalf("jwks requests = %d, want 1", idp.jwksRequests)
+	}
+}
+
+func TestIssuerWithTrailingSlash(t *testing.T) {
+	idp := newMockIdP(t, "/")
+	if !strings.HasSuffix(idp.issuer, "/") {
+		t.Fatalf("issuer %q", idp.issuer)
+	}
+	if _, err := login(t, idp, idp.provider(t, idp.issuer)); err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	// 配置的issuer与发现文档、ID令牌不一致时拒绝
+	if _, err := idp.provider(t, strings.TrimSuffix(idp.issuer, "/")).AuthCodeURL(context.Background(), &Login{}); err == nil {
+		t.Fatal("AuthCodeURL succeeded with a mismatched issuer")
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	idp := newMockIdP(t, "/realms/hellogo")
+	idp.discoveryIssuer = "https://evil.example.com/realms/hellogo"
+	if _, err := idp.provider(t, idp.issuer).AuthCodeURL(context.Background(), &Login{}); err == nil {
+		t.Fatal("AuthCodeURL succeeded with a mismatched discovery issuer")
+	}
+}
+
+func TestPKCEVerifierMismatch(t *testing.T) {
+	idp := newMockIdP(t, "/realms/hellogo")
+	p := idp.provider(t, idp.issuer)
+	l, _ := NewLogin()
+	authURL, err := p.AuthCodeURL(context.Background(), l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := idp.authorize(authURL)
+	other, _ := NewLogin()
+	l.Verifier = other.Verifier
+	if _, err := p.Exchange(context.Background(), code, l); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("Exchange err = %v, want invalid_grant", err)
+	}
+}
+
+func TestNonceMismatch(t *testing.T) {
+	idp := newMockIdP(t, "/realms/hellogo")
+	p := idp.provider(t, idp.issuer)
+	idp.claims = func(claims map[string]interface{}) { claims["nonce"] = "replayed" }
+	if _, err := login(t, idp, p); !errors.Is(err, ErrIDToken) || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("Exchange err = %v, want nonce mismatch", err)
+	}
+}
+
+func TestIDTokenClaims(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(claims map[string]interface{})
+	}{
+		{"issuer", func(c map[string]interface{}) { c["iss"] = c["iss"].(string) + "/" }},
+		{"audience", func(c map[string]interface{}) { c["aud"] = "other" }},
+		{"authorized party", func(c map[string]interface{}) { c["aud"] = []string{testClientID, "other"} }},
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"issued in the future", func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() }},
+		{"subject", func(c map[string]interface{}) { delete(c, "sub") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newMockIdP(t, "/realms/hellogo")
+			idp.claims = tt.modify
+			if _, err := login(t, idp, idp.provider(t, idp.issuer)); !errors.Is(err, ErrIDToken) {
+				t.Fatalf("Exchange err = %v, want ErrIDToken", err)
+			}
+		})
+	}
+}
+
+func TestCheckState(t *testing.T) {
+	l, err := NewLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.CheckState(l.State); err != nil {
+		t.Fatalf("CheckState: %v", err)
+	}
+	for _, state := range []string{"", "forged", l.State + "x"} {
+		if err := l.CheckState(state); !errors.Is(err, ErrState) {
+			t.Fatalf("CheckState(%q) = %v, want ErrState", state, err)
+		}
+	}
+	// 会话中没有state时（没有经过登录或者已经用过）总是拒绝
+	if err := (&Login{}).CheckState(""); !errors.Is(err, ErrState) {
+		t.Fatalf("CheckState on an empty login = %v, want ErrState", err)
+	}
+}
@@ -0,0 +1,234 @@
+package outbound
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen 熔断器打开，请求没有发给上游
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// State 熔断器状态
+type State int
+
+const (
+	// Closed 正常放行
+	Closed State = iota
+	// Open 连续失败后拒绝请求，等待一段时间后进入半开
+	Open
+	// HalfOpen 放行少量探测请求，成功则关闭，失败则重新打开
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// MarshalText 以字符串输出到JSON
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// OpenError 熔断器打开时返回的错误
+type OpenError struct {
+	Host string
+	// RetryAfter 距离下一次允许探测的时间
+	RetryAfter time.Duration
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("%s: %s, retry after %s", ErrCircuitOpen, e.Host, (e.RetryAfter + time.Second - 1).Truncate(time.Second))
+}
+
+func (e *OpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// outcome 一次请求对熔断器的影响
+type outcome int
+
+const (
+	success outcome = iota
+	failure
+	// ignored 调用方取消等与上游无关的结果，不计入成功或失败
+	ignored
+)
+
+// BreakerState 熔断器的快照，用于管理接口
+type BreakerState struct {
+	Host                string     `json:"host"`
+	State               State      `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	TotalSuccesses      int64      `json:"total_successes"`
+	TotalFailures       int64      `json:"total_failures"`
+	Rejected            int64      `json:"rejected"`
+	LastError           string     `json:"last_error,omitempty"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+}
+
+// breaker 单个主机的熔断器
+type breaker struct {
+	host string
+	opts *Options
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	probes    int
+	openedAt  time.Time
+	lastError string
+	successes int64
+	total     int64
+	rejected  int64
+}
+
+// allow 判断是否放行请求，打开超过OpenTimeout后转为半开并放行有限的探测请求，
+// 返回的probe表示是否为半开状态下的探测请求
+func (b *breaker) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open {
+		if wait := b.opts.OpenTimeout - time.Since(b.openedAt); wait > 0 {
+			b.rejected++
+			return false, &OpenError{Host: b.host, RetryAfter: wait}
+		}
+		b.transition(HalfOpen)
+	}
+	if b.state == HalfOpen {
+		if b.probes >= b.opts.HalfOpenProbes {
+			b.rejected++
+			return false, &OpenError{Host: b.host, RetryAfter: b.opts.OpenTimeout}
+		}
+		b.probes++
+		return true, nil
+	}
+	return false, nil
+}
+
+// record 记录请求结果
+func (b *breaker) record(probe bool, o outcome, err string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe && b.state == HalfOpen && b.probes > 0 {
+		b.probes--
+	}
+	switch o {
+	case success:
+		b.successes++
+		b.failures = 0
+		if b.state == HalfOpen && probe {
+			b.transition(Closed)
+		}
+	case failure:
+		b.total++
+		b.failures++
+		b.lastError = err
+		// 已经打开时不再推迟探测时间
+		if b.state == HalfOpen && probe || b.state == Closed && b.failures >= b.opts.FailureThreshold {
+			b.openedAt = time.Now()
+			b.transition(Open)
+		}
+	}
+}
+
+// isOpen 熔断器是否处于打开状态
+func (b *breaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == Open
+}
+
+// reset 手动关闭熔断器
+func (b *breaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probes = 0
+	b.transition(Closed)
+}
+
+// transition 切换状态，调用方需持有锁
+func (b *breaker) transition(to State) {
+	from := b.state
+	b.state = to
+	if to != HalfOpen {
+		b.probes = 0
+	}
+	if from != to && b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(b.host, from, to)
+	}
+}
+
+func (b *breaker) snapshot() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := BreakerState{
+		Host:                b.host,
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		TotalSuccesses:      b.successes,
+		TotalFailures:       b.total,
+		Rejected:            b.rejected,
+		LastError:           b.lastError,
+	}
+	if b.state != Closed {
+		openedAt, retryAt := b.openedAt, b.openedAt.Add(b.opts.OpenTimeout)
+		s.OpenedAt, s.RetryAt = &openedAt, &retryAt
+	}
+	return s
+}
+
+// breakers 按主机管理熔断器
+type breakers struct {
+	opts *Options
+
+	mu    sync.Mutex
+	hosts map[string]*breaker
+}
+
+func (bs *breakers) get(host string) *breaker {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.hosts[host]
+	if !ok {
+		b = &breaker{host: host, opts: bs.opts}
+		bs.hosts[host] = b
+	}
+	return b
+}
+
+func (bs *breakers) states() []BreakerState {
+	bs.mu.Lock()
+	list := make([]*breaker, 0, len(bs.hosts))
+	for _, b := range bs.hosts {
+		list = append(list, b)
+	}
+	bs.mu.Unlock()
+
+	states := make([]BreakerState, 0, len(list))
+	for _, b := range list {
+		states = append(states, b.snapshot())
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Host < states[j].Host })
+	return states
+}
+
+func (bs *breakers) reset(host string) bool {
+	bs.mu.Lock()
+	b, ok := bs.hosts[host]
+	bs.mu.Unlock()
+	if ok {
+		b.reset()
+	}
+	return ok
+}
@@ -0,0 +1,144 @@
+package outbound
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestBreaker 创建记录状态变化的熔断器
+func newTestBreaker(opts Options) (*breaker, *[]string) {
+	var changes []string
+	opts.OnStateChange = func(host string, from, to State) {
+		changes = append(changes, from.String()+"->"+to.String())
+	}
+	return &breaker{host: "upstream", opts: &opts}, &changes
+}
+
+// expire 让打开的熔断器立即可以探测，不用等待OpenTimeout
+func expire(b *breaker) {
+	b.mu.Lock()
+	b.openedAt = time.Now().Add(-b.opts.OpenTimeout)
+	b.mu.Unlock()
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	b, changes := newTestBreaker(Options{FailureThreshold: 2, OpenTimeout: time.Minute, HalfOpenProbes: 1})
+
+	// 成功会清零连续失败次数
+	b.record(false, failure, "boom")
+	b.record(false, success, "")
+	b.record(false, failure, "boom")
+	if b.isOpen() {
+		t.Fatal("opened before reaching the consecutive failure threshold")
+	}
+	b.record(false, failure, "boom")
+	if !b.isOpen() {
+		t.Fatal("still closed after reaching the failure threshold")
+	}
+
+	_, err := b.allow()
+	var openErr *OpenError
+	if !errors.As(err, &openErr) || !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow while open err = %v, want OpenError", err)
+	}
+	if openErr.RetryAfter <= 0 || openErr.RetryAfter > time.Minute {
+		t.Fatalf("RetryAfter = %s", openErr.RetryAfter)
+	}
+
+	// 探测失败重新打开
+	expire(b)
+	probe, err := b.allow()
+	if err != nil || !probe {
+		t.Fatalf("allow after OpenTimeout = %v, %v, want a probe", probe, err)
+	}
+	b.record(probe, failure, "boom")
+	if !b.isOpen() {
+		t.Fatal("failed probe did not reopen the breaker")
+	}
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow after failed probe err = %v, want ErrCircuitOpen", err)
+	}
+
+	// 探测成功关闭
+	expire(b)
+	probe, err = b.allow()
+	if err != nil || !probe {
+		t.Fatalf("allow after OpenTimeout = %v, %v, want a probe", probe, err)
+	}
+	b.record(probe, success, "")
+	if probe, err := b.allow(); err != nil || probe {
+		t.Fatalf("allow after successful probe = %v, %v, want a normal request", probe, err)
+	}
+
+	want := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+	if len(*changes) != len(want) {
+		t.Fatalf("state changes = %v, want %v", *changes, want)
+	}
+	for i := range want {
+		if (*changes)[i] != want[i] {
+			t.Fatalf("state changes = %v, want %v", *changes, want)
+		}
+	}
+
+	s := b.snapshot()
+	if s.State != Closed || s.TotalFailures != 4 || s.TotalSuccesses != 2 || s.Rejected != 2 || s.LastError != "boom" {
+		t.Fatalf("snapshot = %+v", s)
+	}
+}
+
+func TestBreakerProbes(t *testing.T) {
+	b, _ := newTestBreaker(Options{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenProbes: 2})
+	b.record(false, failure, "boom")
+	expire(b)
+
+	// 半开状态下最多同时放行HalfOpenProbes个探测
+	for i := 0; i < 2; i++ {
+		if probe, err := b.allow(); err != nil || !probe {
+			t.Fatalf("probe %d = %v, %v", i, probe, err)
+		}
+	}
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow beyond HalfOpenProbes err = %v, want ErrCircuitOpen", err)
+	}
+
+	// 被忽略的探测归还名额，但不改变状态
+	b.record(true, ignored, "")
+	if s := b.snapshot(); s.State != HalfOpen {
+		t.Fatalf("state after ignored probe = %s, want half-open", s.State)
+	}
+	probe, err := b.allow()
+	if err != nil || !probe {
+		t.Fatalf("allow after ignored probe = %v, %v, want a probe", probe, err)
+	}
+
+	// 打开之前发出的普通请求在半开期间返回，不影响状态
+	b.record(false, failure, "late")
+	b.record(false, success, "")
+	if s := b.snapshot(); s.State != HalfOpen {
+		t.Fatalf("state after non-probe results = %s, want half-open", s.State)
+	}
+
+	b.record(true, success, "")
+	if s := b.snapshot(); s.State != Closed {
+		t.Fatalf("state after successful probe = %s, want closed", s.State)
+	}
+	if b.probes != 0 {
+		t.Fatalf("probes = %d after closing, want 0", b.probes)
+	}
+}
+
+func TestBreakerReset(t *testing.T) {
+	b, _ := newTestBreaker(Options{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenProbes: 1})
+	b.record(false, failure, "boom")
+	if !b.isOpen() {
+		t.Fatal("breaker did not open")
+	}
+	b.reset()
+	if probe, err := b.allow(); err != nil || probe {
+		t.Fatalf("allow after reset = %v, %v, want a normal request", probe, err)
+	}
+	if s := b.snapshot(); s.State != Closed || s.ConsecutiveFailures != 0 {
+		t.Fatalf("snapshot after reset = %+v", s)
+	}
+}
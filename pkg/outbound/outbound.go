@@ -0,0 +1,216 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Options 出站请求的熔断和重试策略
+type Options struct {
+	// FailureThreshold 连续失败多少次后打开熔断器
+	FailureThreshold int
+	// OpenTimeout 熔断器打开后多久进入半开状态
+	OpenTimeout time.Duration
+	// HalfOpenProbes 半开状态下同时放行的探测请求数
+	HalfOpenProbes int
+	// MaxRetries 幂等请求失败后的最大重试次数
+	MaxRetries int
+	// RetryBackoff 第一次重试前的等待时间，之后每次翻倍并加上随机抖动
+	RetryBackoff time.Duration
+	// MaxBackoff 单次重试等待时间的上限，也是接受上游Retry-After的上限
+	MaxBackoff time.Duration
+	// OnStateChange 熔断器状态变化时的回调，用于记录日志
+	OnStateChange func(host string, from, to State)
+}
+
+// DefaultOptions 默认策略
+func DefaultOptions() Options {
+	return Options{
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+		HalfOpenProbes:   1,
+		MaxRetries:       2,
+		RetryBackoff:     100 * time.Millisecond,
+		MaxBackoff:       2 * time.Second,
+	}
+}
+
+// Client 共享的出站HTTP客户端，按主机熔断并重试幂等请求
+type Client struct {
+	opts      Options
+	breakers  *breakers
+	transport *Transport
+}
+
+// New 创建出站客户端，base为nil时使用http.DefaultTransport
+func New(opts Options, base http.RoundTripper) *Client {
+	def := DefaultOptions()
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = def.FailureThreshold
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = def.OpenTimeout
+	}
+	if opts.HalfOpenProbes <= 0 {
+		opts.HalfOpenProbes = def.HalfOpenProbes
+	}
+	if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = def.RetryBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = def.MaxBackoff
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	c := &Client{opts: opts}
+	c.breakers = &breakers{opts: &c.opts, hosts: map[string]*breaker{}}
+	c.transport = &Transport{base: base, client: c}
+	return c
+}
+
+// HTTPClient 使用熔断和重试的http.Client，超时由调用方通过context控制
+func (c *Client) HTTPClient() *http.Client {
+	return &http.Client{Transport: c.transport}
+}
+
+// Transport 熔断和重试的RoundTripper
+func (c *Client) Transport() http.RoundTripper {
+	return c.transport
+}
+
+// Breakers 所有主机的熔断器状态
+func (c *Client) Breakers() []BreakerState {
+	return c.breakers.states()
+}
+
+// ResetBreaker 手动关闭主机的熔断器，主机没有熔断器时返回false
+func (c *Client) ResetBreaker(host string) bool {
+	return c.breakers.reset(host)
+}
+
+// Transport 在每次尝试前检查熔断器，上游失败时按指数退避重试幂等请求
+type Transport struct {
+	base   http.RoundTripper
+	client *Client
+}
+
+// RoundTrip 实现http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := t.client.breakers.get(req.URL.Host)
+	opts := t.client.opts
+	attempts := 1
+	if idempotent(req) {
+		attempts += opts.MaxRetries
+	}
+
+	for i := 0; ; i++ {
+		probe, err := b.allow()
+		if err != nil {
+			return nil, err
+		}
+
+		attempt := req
+		if i > 0 && req.Body != nil && req.Body != http.NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				b.record(probe, ignored, "")
+				return nil, err
+			}
+			attempt = req.Clone(req.Context())
+			attempt.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(attempt)
+		switch {
+		case err != nil && errors.Is(req.Context().Err(), context.Canceled):
+			// 调用方取消，与上游是否正常无关；超时说明上游没有及时响应，计为失败
+			b.record(probe, ignored, "")
+			return nil, err
+		case err != nil:
+			b.record(probe, failure, err.Error())
+		case serverFailure(resp.StatusCode):
+			b.record(probe, failure, resp.Status)
+		default:
+			b.record(probe, success, "")
+		}
+
+		// 本次失败导致熔断或者已经超时时不再重试，返回上游的实际结果
+		if i+1 >= attempts || !shouldRetry(resp, err) || b.isOpen() || req.Context().Err() != nil {
+			return resp, err
+		}
+		wait := backoff(opts, i, resp)
+		if resp != nil {
+			// 读完响应体以便复用连接
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// idempotent 可以安全重试的请求：幂等方法且能重新读取请求体，或者带了Idempotency-Key
+func idempotent(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// serverFailure 计入熔断器失败次数的状态码
+func serverFailure(status int) bool {
+	switch status {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// shouldRetry 网络错误、网关错误和限流时重试
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff 第i次失败后的等待时间：上游给了Retry-After（秒）时按它等待，否则指数退避加随机抖动
+func backoff(opts Options, i int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+			if wait := time.Duration(secs) * time.Second; wait < opts.MaxBackoff {
+				return wait
+			}
+			return opts.MaxBackoff
+		}
+	}
+	wait := opts.RetryBackoff << uint(i)
+	if wait <= 0 || wait > opts.MaxBackoff {
+		wait = opts.MaxBackoff
+	}
+	// 在[wait/2, wait]之间随机，避免多个客户端同时重试
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
@@ -0,0 +1,291 @@
+package outbound
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient 重试等待很短的客户端，熔断阈值足够大，除非测试需要否则不会打开
+func newTestClient(configure func(opts *Options)) *Client {
+	opts := Options{
+		FailureThreshold: 100,
+		OpenTimeout:      time.Minute,
+		MaxRetries:       2,
+		RetryBackoff:     time.Millisecond,
+		MaxBackoff:       5 * time.Millisecond,
+	}
+	if configure != nil {
+		configure(&opts)
+	}
+	return New(opts, nil)
+}
+
+// breakerFor 取出测试上游的熔断器状态
+func breakerFor(t *testing.T, c *Client, upstream *httptest.Server) BreakerState {
+	t.Helper()
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	for _, s := range c.Breakers() {
+		if s.Host == host {
+			return s
+		}
+	}
+	t.Fatalf("no breaker for %s", host)
+	return BreakerState{}
+}
+
+func TestRetryIdempotentRequests(t *testing.T) {
+	var hits int32
+	var bodies []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		// 每个请求的前两次尝试返回503
+		if atomic.AddInt32(&hits, 1)%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+	client := newTestClient(nil).HTTPClient()
+
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		hits   int32
+		status int
+	}{
+		{"GET", func() *http.Request {
+			req, _ := http.NewRequest(http.MethodGet, upstream.URL, nil)
+			return req
+		}, 3, http.StatusOK},
+		{"PUT with rewindable body", func() *http.Request {
+			req, _ := http.NewRequest(http.MethodPut, upstream.URL, strings.NewReader("payload"))
+			return req
+		}, 3, http.StatusOK},
+		{"POST with Idempotency-Key", func() *http.Request {
+			req, _ := http.NewRequest(http.MethodPost, upstream.URL, bytes.NewReader([]byte("payload")))
+			req.Header.Set("Idempotency-Key", "abc")
+			return req
+		}, 3, http.StatusOK},
+		{"POST", func() *http.Request {
+			req, _ := http.NewRequest(http.MethodPost, upstream.URL, strings.NewReader("payload"))
+			return req
+		}, 1, http.StatusServiceUnavailable},
+		{"PUT with body that cannot be re-read", func() *http.Request {
+			req, _ := http.NewRequest(http.MethodPut, upstream.URL, io.NopCloser(strings.NewReader("payload")))
+			return req
+		}, 1, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&hits, 0)
+			bodies = nil
+			req := tt.req()
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if n := atomic.LoadInt32(&hits); n != tt.hits {
+				t.Fatalf("upstream hits = %d, want %d", n, tt.hits)
+			}
+			// 重试时完整地重新发送请求体
+			if req.Body != nil {
+				for i, body := range bodies {
+					if body != "payload" {
+						t.Fatalf("attempt %d body = %q", i, body)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRetryStatusCodes(t *testing.T) {
+	status := http.StatusOK
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(status)
+	}))
+	defer upstream.Close()
+	c := newTestClient(nil)
+
+	tests := []struct {
+		status   int
+		hits     int32
+		failures int64
+	}{
+		// 网关错误重试并计为失败
+		{http.StatusBadGateway, 3, 3},
+		// 限流重试但不算上游故障
+		{http.StatusTooManyRequests, 3, 0},
+		// 500可能是请求本身的问题，不重试但计为失败
+		{http.StatusInternalServerError, 1, 1},
+		{http.StatusNotFound, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			status = tt.status
+			atomic.StoreInt32(&hits, 0)
+			before := breakerFailures(c)
+
+			resp, err := c.HTTPClient().Get(upstream.URL)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if n := atomic.LoadInt32(&hits); n != tt.hits {
+				t.Fatalf("upstream hits = %d, want %d", n, tt.hits)
+			}
+			if n := breakerFailures(c) - before; n != tt.failures {
+				t.Fatalf("breaker failures = %d, want %d", n, tt.failures)
+			}
+		})
+	}
+}
+
+// breakerFailures 所有熔断器累计的失败次数
+func breakerFailures(c *Client) int64 {
+	var n int64
+	for _, s := range c.Breakers() {
+		n += s.TotalFailures
+	}
+	return n
+}
+
+func TestBreakerStopsRetries(t *testing.T) {
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+	c := newTestClient(func(opts *Options) {
+		opts.FailureThreshold = 2
+		opts.MaxRetries = 5
+	})
+
+	// 熔断器打开后不再重试，返回上游的实际结果
+	resp, err := c.HTTPClient().Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Fatalf("upstream hits = %d, want 2", n)
+	}
+
+	// 打开期间请求不发给上游
+	_, err = c.HTTPClient().Get(upstream.URL)
+	var openErr *OpenError
+	if !errors.As(err, &openErr) {
+		t.Fatalf("Get while open err = %v, want OpenError", err)
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Fatalf("upstream hits = %d while open, want 2", n)
+	}
+	if s := breakerFor(t, c, upstream); s.State != Open || s.Rejected != 1 {
+		t.Fatalf("breaker = %+v", s)
+	}
+
+	if !c.ResetBreaker(openErr.Host) {
+		t.Fatalf("ResetBreaker(%s) = false", openErr.Host)
+	}
+	if s := breakerFor(t, c, upstream); s.State != Closed {
+		t.Fatalf("state after reset = %s", s.State)
+	}
+}
+
+func TestTimeoutCountsAsFailure(t *testing.T) {
+	release := make(chan struct{})
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+	c := newTestClient(func(opts *Options) { opts.FailureThreshold = 1 })
+
+	// 超时说明上游没有及时响应，计为失败，也不再重试
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL, nil)
+	if _, err := c.HTTPClient().Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Do err = %v, want DeadlineExceeded", err)
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Fatalf("upstream hits = %d, want 1", n)
+	}
+	if s := breakerFor(t, c, upstream); s.State != Open || s.TotalFailures != 1 {
+		t.Fatalf("breaker after timeout = %+v", s)
+	}
+}
+
+func TestCancelIgnored(t *testing.T) {
+	release := make(chan struct{})
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+	c := newTestClient(func(opts *Options) { opts.FailureThreshold = 1 })
+
+	// 调用方取消与上游是否正常无关，不计入失败也不重试
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL, nil)
+	if _, err := c.HTTPClient().Do(req); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Do err = %v, want Canceled", err)
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Fatalf("upstream hits = %d, want 1", n)
+	}
+	if s := breakerFor(t, c, upstream); s.State != Closed || s.TotalFailures != 0 || s.ConsecutiveFailures != 0 {
+		t.Fatalf("breaker after cancel = %+v", s)
+	}
+
+	// 取消的探测同样不影响半开状态
+	b := c.breakers.get(strings.TrimPrefix(upstream.URL, "http://"))
+	b.record(false, failure, "boom")
+	expire(b)
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL, nil)
+	if _, err := c.HTTPClient().Do(req); !errors.Is(err, context.Canceled) {
+		t.Fatalf("probe Do err = %v, want Canceled", err)
+	}
+	if s := breakerFor(t, c, upstream); s.State != HalfOpen {
+		t.Fatalf("state after cancelled probe = %s, want half-open", s.State)
+	}
+	if probe, err := b.allow(); err != nil || !probe {
+		t.Fatalf("allow after cancelled probe = %v, %v, want the probe slot back", probe, err)
+	}
+}
@@ -0,0 +1,199 @@
+package progress
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrInUse 同一个上传ID正在上传或者刚结束
+	ErrInUse = errors.New("upload id is already in use")
+
+	// ErrNotFound 上传不存在，或者不属于订阅者
+	ErrNotFound = errors.New("upload not found")
+)
+
+// Status 上传状态
+type Status string
+
+const (
+	// Uploading 上传中
+	Uploading Status = "uploading"
+	// Completed 上传成功
+	Completed Status = "completed"
+	// Failed 上传失败
+	Failed Status = "failed"
+)
+
+// Final 是否为最终状态
+func (s Status) Final() bool {
+	return s == Completed || s == Failed
+}
+
+// idPattern 上传ID由客户端生成，需要足够长以免被猜到
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{16,64}$`)
+
+// ValidID 检查上传ID的格式
+func ValidID(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+// Snapshot 某一时刻的上传进度
+type Snapshot struct {
+	ID       string `json:"id"`
+	Status   Status `json:"status"`
+	Received int64  `json:"received"`
+	// Total 请求体的总长度，未知时为0
+	Total          int64   `json:"total,omitempty"`
+	Percent        float64 `json:"percent"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	// HTTPStatus 上传请求的响应状态码，上传结束后才有
+	HTTPStatus int    `json:"http_status,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Upload 一个上传的进度，接收的字节数通过原子操作更新，读请求体时不加锁
+type Upload struct {
+	id       string
+	owner    string
+	received int64
+
+	mu         sync.Mutex
+	status     Status
+	total      int64
+	httpStatus int
+	err        string
+	started    time.Time
+	finished   time.Time
+	done       chan struct{}
+}
+
+// Body 包装请求体，统计已经读取的字节数
+func (u *Upload) Body(body io.ReadCloser) io.ReadCloser {
+	return &countingBody{ReadCloser: body, u: u}
+}
+
+// Finish 上传结束，按响应状态码记录成功或失败
+func (u *Upload) Finish(httpStatus int, err string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.status.Final() {
+		return
+	}
+	u.status = Completed
+	if httpStatus >= 400 {
+		u.status = Failed
+	}
+	u.httpStatus = httpStatus
+	u.err = err
+	u.finished = time.Now()
+	close(u.done)
+}
+
+// Done 上传结束时关闭
+func (u *Upload) Done() <-chan struct{} {
+	return u.done
+}
+
+// Snapshot 当前进度，吞吐量为开始上传以来的平均速度
+func (u *Upload) Snapshot() Snapshot {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	s := Snapshot{
+		ID:         u.id,
+		Status:     u.status,
+		Received:   atomic.LoadInt64(&u.received),
+		Total:      u.total,
+		HTTPStatus: u.httpStatus,
+		Error:      u.err,
+	}
+	if s.Total > 0 {
+		s.Percent = float64(s.Received) * 100 / float64(s.Total)
+	}
+	if s.Status == Completed {
+		s.Percent = 100
+	}
+
+	end := time.Now()
+	if !u.finished.IsZero() {
+		end = u.finished
+	}
+	if elapsed := end.Sub(u.started).Seconds(); elapsed > 0 {
+		s.BytesPerSecond = float64(s.Received) / elapsed
+	}
+	return s
+}
+
+// Tracker 按上传ID跟踪进度
+type Tracker struct {
+	// retain 上传结束后保留进度的时间
+	retain time.Duration
+
+	mu      sync.Mutex
+	uploads map[string]*Upload
+}
+
+// NewTracker 创建进度跟踪
+func NewTracker(retain time.Duration) *Tracker {
+	return &Tracker{retain: retain, uploads: map[string]*Upload{}}
+}
+
+// Start 开始跟踪一个上传，owner为上传者，匿名上传时为空；total为请求体长度，未知时为-1。
+// 同一个ID正在上传或者结束后还没有清理时返回ErrInUse
+func (t *Tracker) Start(id, owner string, total int64) (*Upload, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.uploads[id]; ok {
+		return nil, ErrInUse
+	}
+	u := &Upload{id: id, owner: owner, status: Uploading, started: time.Now(), done: make(chan struct{})}
+	if total > 0 {
+		u.total = total
+	}
+	t.uploads[id] = u
+	return u, nil
+}
+
+// Watch 订阅一个已经开始的上传的进度，只能订阅自己的上传，匿名上传只能匿名订阅。
+// 不存在或者不属于订阅者时返回ErrNotFound，订阅不会创建记录
+func (t *Tracker) Watch(id, owner string) (*Upload, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u, ok := t.uploads[id]
+	if !ok || u.owner != owner {
+		return nil, ErrNotFound
+	}
+	return u, nil
+}
+
+// Cleanup 删除结束超过保留时间的记录
+func (t *Tracker) Cleanup() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	removed := 0
+	for id, u := range t.uploads {
+		u.mu.Lock()
+		if u.status.Final() && time.Since(u.finished) > t.retain {
+			delete(t.uploads, id)
+			removed++
+		}
+		u.mu.Unlock()
+	}
+	return removed
+}
+
+// countingBody 统计读取字节数的请求体
+type countingBody struct {
+	io.ReadCloser
+	u *Upload
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.u.received, int64(n))
+	return n, err
+}
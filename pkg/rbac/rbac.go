@@ -0,0 +1,100 @@
+package rbac
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Policy 角色和权限的对应关系。权限形如"资源:操作"，可以使用通配符，
+// 例如"*"表示全部权限，"*:read"表示所有资源的只读权限
+type Policy struct {
+	Roles map[string][]string `yaml:"roles"`
+}
+
+// DefaultPolicy 默认策略：admin拥有全部权限，operator只能查看
+func DefaultPolicy() *Policy {
+	return &Policy{Roles: map[string][]string{
+		"admin":    {"*"},
+		"operator": {"*:read"},
+	}}
+}
+
+// LoadPolicy 从yaml文件加载策略，文件不存在时使用默认策略。文件形如：
+//
+//	roles:
+//	  admin: ["*"]
+//	  operator: ["*:read", "breakers:reset"]
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return DefaultPolicy(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for role, perms := range p.Roles {
+		for _, perm := range perms {
+			if !ValidPattern(perm) {
+				return nil, fmt.Errorf("%s: role %s: invalid permission %q", file, role, perm)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// HasRole 策略中是否定义了该角色
+func (p *Policy) HasRole(role string) bool {
+	_, ok := p.Roles[role]
+	return ok
+}
+
+// RoleNames 按名称排序的全部角色
+func (p *Policy) RoleNames() []string {
+	names := make([]string, 0, len(p.Roles))
+	for name := range p.Roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Allowed 任一角色拥有该权限时返回true，未定义的角色没有任何权限
+func (p *Policy) Allowed(roles []string, permission string) bool {
+	for _, role := range roles {
+		if Match(p.Roles[role], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match 权限是否匹配任一模式
+func Match(patterns []string, permission string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, permission); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidPattern 是否为合法的权限模式，只允许小写字母、数字、"_"、"-"、":"和通配符"*"
+func ValidPattern(pattern string) bool {
+	if pattern == "" {
+		return false
+	}
+	for _, r := range pattern {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || strings.ContainsRune("_-:*", r)) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,7 +1,350 @@
 package read
 
-import "fmt"
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin/binding"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/gin/types"
+	"github.com/qinchy/hellogo/pkg/compress"
+	"github.com/qinchy/hellogo/pkg/deadletter"
+	"github.com/qinchy/hellogo/pkg/manifest"
+	"github.com/qinchy/hellogo/pkg/write"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
 
+// inboxPatterns 收件目录中会被读取的文件，压缩文件通过魔数自动识别
+var inboxPatterns = []string{"*.jsonl", "*.jsonl.gz", "*.jsonl.zst", "*.jsonl.bz2"}
+
+// Handler 处理一条通过解析和校验的记录
+type Handler func(rec *types.Record) error
+
+// Result 一次读取的统计结果
+type Result struct {
+	Source   string `json:"source"`
+	Total    int    `json:"total"`
+	Accepted int    `json:"accepted"`
+	Rejected int    `json:"rejected"`
+	// DeadLetters 被拒绝的记录，由调用方在输出写完后通过CommitDeadLetters提交
+	DeadLetters []deadletter.Entry `json:"-"`
+}
+
+// ReadFile 读取收件目录下的全部文件，通过的记录写出到输出目录，处理完成的文件重命名为.done
 func ReadFile() {
-	fmt.Println("模拟读取文件。")
+	var paths []string
+	for _, pattern := range inboxPatterns {
+		matches, err := filepath.Glob(filepath.Join(Conf.InboxDir, pattern))
+		if err != nil {
+			Logger.Errorf("扫描收件目录时出现异常：%s", err.Error())
+			return
+		}
+		paths = append(paths, matches...)
+	}
+
+	for _, path := range paths {
+		result, output, err := export(path)
+		if err == nil {
+			err = CommitDeadLetters(result)
+		}
+		if err != nil {
+			// 输入保留在收件目录，下次重新读取；已经提交的死信不会重复写入
+			Logger.Errorf("读取文件%s时出现异常：%s", path, err.Error())
+			continue
+		}
+		Logger.WithFields(logrus.Fields{
+			"source":   result.Source,
+			"output":   output,
+			"total":    result.Total,
+			"accepted": result.Accepted,
+			"rejected": result.Rejected,
+		}).Info("文件读取完成")
+
+		if err := os.Rename(path, path+".done"); err != nil {
+			Logger.Errorf("重命名文件%s时出现异常：%s", path, err.Error())
+		}
+		if _, err := os.Stat(manifest.PathFor(path)); err == nil {
+			os.Rename(manifest.PathFor(path), manifest.PathFor(path+".done"))
+		}
+	}
+}
+
+// export 读取单个文件并把通过的记录写出到输出目录，压缩格式按配置，同时生成清单。
+// 写出失败时删除输出，输入文件保持原样等待下次重试
+func export(path string) (*Result, string, error) {
+	sink, err := write.Create(outputPath(path), write.DefaultOptions())
+	if err != nil {
+		return nil, "", err
+	}
+	result, err := ReadPath(path, func(rec *types.Record) error {
+		if err := LogRecord(rec); err != nil {
+			return err
+		}
+		// 写出失败不是记录本身的问题，不写入死信
+		sink.Write(rec)
+		return nil
+	})
+	if err != nil {
+		sink.Abort()
+		return nil, "", err
+	}
+	if err := sink.Close(); err != nil {
+		return nil, "", fmt.Errorf("write %s: %w", sink.Path(), err)
+	}
+	return result, sink.Path(), nil
+}
+
+// outputPath 输入文件对应的输出路径，去掉输入的压缩扩展名，输出的扩展名由压缩配置决定
+func outputPath(path string) string {
+	name := filepath.Base(path)
+	for _, codec := range []compress.Codec{compress.Gzip, compress.Zstd, compress.Bzip2} {
+		name = strings.TrimSuffix(name, codec.Ext())
+	}
+	return filepath.Join(Conf.OutputDir, name)
+}
+
+// ReadPath 读取单个文件，存在清单时先校验，校验失败的文件移入隔离目录
+func ReadPath(path string, handle Handler) (*Result, error) {
+	m, err := verifyManifest(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result, err := Read(f, path, handle)
+	if err == nil && m != nil && m.Records != result.Total {
+		Logger.WithFields(logrus.Fields{
+			"source":   path,
+			"records":  result.Total,
+			"manifest": m.Records,
+		}).Warn("读取的记录数与清单不一致")
+	}
+	return result, err
+}
+
+// verifyManifest 校验文件的清单，没有清单时按配置决定是否放行
+func verifyManifest(path string) (*manifest.Manifest, error) {
+	m, err := manifest.Load(path)
+	if os.IsNotExist(err) {
+		if Conf.RequireManifest {
+			return nil, fmt.Errorf("manifest %s is required", manifest.PathFor(path))
+		}
+		return nil, nil
+	}
+	if err == nil {
+		err = manifest.Verify(path, m)
+	}
+	if err != nil {
+		if qerr := quarantine(path); qerr != nil {
+			Logger.Errorf("隔离文件%s时出现异常：%s", path, qerr.Error())
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// quarantine 将文件及其清单移入隔离目录
+func quarantine(path string) error {
+	if err := os.MkdirAll(Conf.QuarantineDir, 0755); err != nil {
+		return err
+	}
+	dst := filepath.Join(Conf.QuarantineDir, filepath.Base(path))
+	if err := os.Rename(path, dst); err != nil {
+		return err
+	}
+	Logger.WithFields(logrus.Fields{
+		"source":     path,
+		"quarantine": dst,
+	}).Warn("文件校验失败，已隔离")
+
+	if _, err := os.Stat(manifest.PathFor(path)); err == nil {
+		return os.Rename(manifest.PathFor(path), manifest.PathFor(dst))
+	}
+	return nil
+}
+
+// Read 按行读取JSON记录，单条记录失败时收集到Result.DeadLetters而不中断整个文件
+// gzip、zstd、bzip2压缩的输入会根据魔数自动流式解压
+func Read(r io.Reader, source string, handle Handler) (*Result, error) {
+	zr, codec, err := compress.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	if codec != compress.None {
+		Logger.WithFields(logrus.Fields{
+			"source":      source,
+			"compression": codec,
+		}).Debug("输入为压缩文件")
+	}
+
+	result := &Result{Source: source}
+	reader := bufio.NewReader(zr)
+	line := 0
+	for {
+		raw, err := reader.ReadBytes('\n')
+		if len(raw) > 0 {
+			line++
+			raw = bytes.TrimSpace(raw)
+			if len(raw) > 0 {
+				result.Total++
+				if entry := process(raw, source, line, handle); entry == nil {
+					result.Accepted++
+				} else {
+					result.Rejected++
+					result.DeadLetters = append(result.DeadLetters, *entry)
+				}
+			}
+		}
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+}
+
+// Parse 解析并校验一条记录，校验使用globalvar中注册到gin的校验器
+func Parse(raw []byte) (*types.Record, error) {
+	var rec types.Record
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rec); err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+	if err := binding.Validator.ValidateStruct(&rec); err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+	return &rec, nil
+}
+
+// RedriveResult 一次重新处理死信的结果
+type RedriveResult struct {
+	// Output 重新处理通过的记录写出的文件，没有记录通过时为空
+	Output   string            `json:"output,omitempty"`
+	Redriven []string          `json:"redriven"`
+	Failed   map[string]string `json:"failed"`
+}
+
+// redriveMu 串行化重新处理，避免同一条死信被并发写出两次
+var redriveMu sync.Mutex
+
+// Redrive 重新处理一批死信，通过的记录写出到输出目录下的redrive-<时间>文件并生成清单，
+// 输出关闭成功后才删除对应的死信；失败的死信更新失败原因和次数
+func Redrive(ids []string, handle Handler) (*RedriveResult, error) {
+	redriveMu.Lock()
+	defer redriveMu.Unlock()
+
+	name := "redrive-" + time.Now().Format("20060102T150405.000000000") + ".jsonl"
+	sink, err := write.Create(filepath.Join(Conf.OutputDir, name), write.DefaultOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RedriveResult{Redriven: []string{}, Failed: map[string]string{}}
+	for _, id := range ids {
+		entry, err := DeadLetters.Get(id)
+		if err != nil {
+			result.Failed[id] = err.Error()
+			continue
+		}
+		rec, err := Parse([]byte(entry.Raw))
+		if err == nil {
+			err = handle(rec)
+		}
+		if err != nil {
+			result.Failed[id] = err.Error()
+			entry.Attempts++
+			entry.Reason = err.Error()
+			if uerr := DeadLetters.Update(entry); uerr != nil {
+				sink.Abort()
+				return nil, uerr
+			}
+			continue
+		}
+		if err := sink.Write(rec); err != nil {
+			sink.Abort()
+			return nil, fmt.Errorf("write %s: %w", sink.Path(), err)
+		}
+		result.Redriven = append(result.Redriven, id)
+	}
+
+	if len(result.Redriven) == 0 {
+		sink.Abort()
+		return result, nil
+	}
+	if err := sink.Close(); err != nil {
+		return nil, fmt.Errorf("write %s: %w", sink.Path(), err)
+	}
+	result.Output = sink.Path()
+	if err := DeadLetters.RemoveAll(result.Redriven); err != nil {
+		return nil, err
+	}
+	Logger.WithFields(logrus.Fields{
+		"output":   result.Output,
+		"redriven": len(result.Redriven),
+		"failed":   len(result.Failed),
+	}).Info("死信重新处理完成")
+	return result, nil
+}
+
+// LogRecord 默认的记录处理器，仅记录日志
+func LogRecord(rec *types.Record) error {
+	Logger.WithFields(logrus.Fields{
+		"id":       rec.ID,
+		"name":     rec.Name,
+		"address":  rec.Address,
+		"check_in": rec.CheckIn,
+	}).Info("读取记录")
+	return nil
+}
+
+// process 处理一行记录，失败时返回对应的死信
+func process(raw []byte, source string, line int, handle Handler) *deadletter.Entry {
+	rec, err := Parse(raw)
+	if err == nil {
+		err = handle(rec)
+	}
+	if err == nil {
+		return nil
+	}
+	Logger.WithFields(logrus.Fields{
+		"source": source,
+		"line":   line,
+		"reason": err.Error(),
+	}).Warn("记录被拒绝")
+	return &deadletter.Entry{
+		Reason: err.Error(),
+		Source: source,
+		Line:   line,
+		Raw:    string(raw),
+	}
+}
+
+// CommitDeadLetters 把一次读取中被拒绝的记录写入死信，同一文件重新读取时跳过已有的死信
+func CommitDeadLetters(result *Result) error {
+	added, err := DeadLetters.AddAll(result.DeadLetters)
+	if err != nil {
+		return fmt.Errorf("dead letters: %w", err)
+	}
+	if added > 0 {
+		Logger.WithFields(logrus.Fields{
+			"source": result.Source,
+			"added":  added,
+		}).Warn("被拒绝的记录已写入死信")
+	}
+	return nil
 }
@@ -0,0 +1,222 @@
+package refresh
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalid 刷新令牌不存在或已被撤销
+	ErrInvalid = errors.New("invalid refresh token")
+	// ErrExpired 刷新令牌已过期
+	ErrExpired = errors.New("refresh token is expired")
+	// ErrReused 已经轮换过的刷新令牌被再次使用，说明令牌可能泄露，整个令牌族已被撤销
+	ErrReused = errors.New("refresh token reused")
+)
+
+// Token 服务端保存的刷新令牌，只保存令牌的SHA-256。
+// 同一次登录后不断轮换产生的令牌属于同一个令牌族，发现重用时撤销整个令牌族
+type Token struct {
+	Hash      string    `json:"hash"`
+	Family    string    `json:"family"`
+	User      string    `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Used 已经被轮换为新令牌，保留到过期以便发现重用
+	Used    bool `json:"used,omitempty"`
+	Revoked bool `json:"revoked,omitempty"`
+}
+
+// Store 刷新令牌存储，每次修改都整体重写JSON文件
+type Store struct {
+	path string
+	ttl  time.Duration
+
+	mu     sync.Mutex
+	tokens map[string]*Token
+}
+
+// Open 打开刷新令牌文件，ttl为每个令牌的有效期，轮换后新令牌重新计算有效期
+func Open(path string, ttl time.Duration) (*Store, error) {
+	s := &Store{path: path, ttl: ttl, tokens: map[string]*Token{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*Token
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, t := range list {
+		s.tokens[t.Hash] = t
+	}
+	return s, nil
+}
+
+// TTL 刷新令牌的有效期
+func (s *Store) TTL() time.Duration {
+	return s.ttl
+}
+
+// Issue 登录时为用户签发新令牌族的第一个令牌
+func (s *Store) Issue(user string) (string, *Token, error) {
+	family, err := randomString(16)
+	if err != nil {
+		return "", nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issue(user, family)
+}
+
+// Rotate 使用刷新令牌换取同一令牌族的新令牌，旧令牌随即失效。
+// 已经使用过的令牌再次出现时撤销整个令牌族并返回ErrReused
+func (s *Store) Rotate(raw string) (string, *Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[hash(raw)]
+	if !ok || t.Revoked {
+		return "", nil, ErrInvalid
+	}
+	if t.Used {
+		s.revokeFamily(t.Family)
+		if err := s.save(); err != nil {
+			return "", nil, err
+		}
+		copied := *t
+		return "", &copied, ErrReused
+	}
+	if time.Now().After(t.ExpiresAt) {
+		return "", nil, ErrExpired
+	}
+
+	t.Used = true
+	token, next, err := s.issue(t.User, t.Family)
+	if err != nil {
+		t.Used = false
+		return "", nil, err
+	}
+	return token, next, nil
+}
+
+// Revoke 撤销令牌所在的整个令牌族，用于退出登录，返回令牌所属的用户
+func (s *Store) Revoke(raw string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[hash(raw)]
+	if !ok {
+		return "", ErrInvalid
+	}
+	s.revokeFamily(t.Family)
+	return t.User, s.save()
+}
+
+// RevokeFamily 撤销整个令牌族
+func (s *Store) RevokeFamily(family string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokeFamily(family)
+	return s.save()
+}
+
+// Cleanup 删除已过期的令牌，返回删除的数量
+func (s *Store) Cleanup() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	removed := 0
+	for h, t := range s.tokens {
+		if now.After(t.ExpiresAt) {
+			delete(s.tokens, h)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.save()
+}
+
+// issue 调用方需持有锁
+func (s *Store) issue(user, family string) (string, *Token, error) {
+	raw, err := randomString(32)
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	t := &Token{
+		Hash:      hash(raw),
+		Family:    family,
+		User:      user,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	}
+	s.tokens[t.Hash] = t
+	if err := s.save(); err != nil {
+		delete(s.tokens, t.Hash)
+		return "", nil, err
+	}
+	copied := *t
+	return raw, &copied, nil
+}
+
+// revokeFamily 调用方需持有锁
+func (s *Store) revokeFamily(family string) {
+	for _, t := range s.tokens {
+		if t.Family == family {
+			t.Revoked = true
+		}
+	}
+}
+
+// save 先写临时文件再重命名，调用方需持有锁
+func (s *Store) save() error {
+	list := make([]*Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		list = append(list, t)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func hash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,128 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize INSTREAM每个数据块的大小，需小于clamd的StreamMaxLength
+const clamdChunkSize = 64 << 10
+
+// Clamd 通过clamd的INSTREAM命令扫描的客户端
+type Clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamd 创建clamd客户端，地址形如 tcp://127.0.0.1:3310 或 unix:///var/run/clamd.sock
+func NewClamd(address string, timeout time.Duration) *Clamd {
+	network := "tcp"
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		network, address = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "tcp://"):
+		address = strings.TrimPrefix(address, "tcp://")
+	}
+	return &Clamd{network: network, address: address, timeout: timeout}
+}
+
+// Name 扫描器名称
+func (c *Clamd) Name() string {
+	return "clamd"
+}
+
+// Ping 检查clamd是否可用
+func (c *Clamd) Ping(ctx context.Context) error {
+	reply, err := c.command(ctx, "zPING\x00", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("unexpected clamd reply %q", reply)
+	}
+	return nil
+}
+
+// Scan 以INSTREAM协议把内容分块发送给clamd：
+// "zINSTREAM\0"，然后是若干个 <4字节大端长度><数据>，最后以长度为0的块结束
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	result := Result{Scanner: c.Name()}
+	reply, err := c.command(ctx, "zINSTREAM\x00", func(w io.Writer) error {
+		buf := make([]byte, 4+clamdChunkSize)
+		for {
+			n, err := io.ReadFull(r, buf[4:])
+			if n > 0 {
+				binary.BigEndian.PutUint32(buf[:4], uint32(n))
+				if _, werr := w.Write(buf[:4+n]); werr != nil {
+					return werr
+				}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		_, err := w.Write([]byte{0, 0, 0, 0})
+		return err
+	})
+	if err != nil {
+		return result, err
+	}
+
+	// 回复形如 "stream: OK"、"stream: Eicar-Signature FOUND"、"INSTREAM size limit exceeded. ERROR"
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return result, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		result.Infected = true
+		result.Signature = strings.TrimSuffix(reply, " FOUND")
+		return result, nil
+	}
+	return result, fmt.Errorf("clamd: %s", reply)
+}
+
+// command 发送一条z开头（以\0结尾）的命令并读取以\0结尾的回复
+func (c *Clamd) command(ctx context.Context, cmd string, body func(io.Writer) error) (string, error) {
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	w := bufio.NewWriter(conn)
+	if _, err := w.WriteString(cmd); err != nil {
+		return "", err
+	}
+	if body != nil {
+		if err := body(w); err != nil {
+			return "", err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && !(err == io.EOF && len(reply) > 0) {
+		return "", err
+	}
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
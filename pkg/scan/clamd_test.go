@@ -0,0 +1,228 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/qinchy/hellogo/pkg/upload"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd 模拟clamd：读取一条INSTREAM命令和全部数据块，然后按reply回复，
+// reply为空时不回复也不关闭连接，直到测试结束
+type fakeClamd struct {
+	ln     net.Listener
+	reply  func(data []byte) string
+	chunks chan []int
+	data   chan []byte
+	done   chan struct{}
+}
+
+func newFakeClamd(t *testing.T, network string, reply func(data []byte) string) *fakeClamd {
+	t.Helper()
+	address := "127.0.0.1:0"
+	if network == "unix" {
+		address = filepath.Join(t.TempDir(), "clamd.sock")
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeClamd{ln: ln, reply: reply, chunks: make(chan []int, 1), data: make(chan []byte, 1), done: make(chan struct{})}
+	t.Cleanup(func() {
+		close(f.done)
+		ln.Close()
+	})
+	go f.serve()
+	return f
+}
+
+func (f *fakeClamd) address() string {
+	return f.ln.Addr().Network() + "://" + f.ln.Addr().String()
+}
+
+func (f *fakeClamd) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeClamd) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	cmd, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	if cmd == "zPING\x00" {
+		conn.Write([]byte("PONG\x00"))
+		return
+	}
+	if cmd != "zINSTREAM\x00" {
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+		return
+	}
+
+	var sizes []int
+	var data bytes.Buffer
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(size[:])
+		if n == 0 {
+			break
+		}
+		sizes = append(sizes, int(n))
+		if _, err := io.CopyN(&data, r, int64(n)); err != nil {
+			return
+		}
+	}
+	f.chunks <- sizes
+	f.data <- data.Bytes()
+	reply := f.reply(data.Bytes())
+	if reply == "" {
+		<-f.done
+		return
+	}
+	conn.Write([]byte(reply + "\x00"))
+}
+
+func TestClamdInstreamChunking(t *testing.T) {
+	fake := newFakeClamd(t, "tcp", func([]byte) string { return "stream: OK" })
+	clamd := NewClamd(fake.address(), time.Second)
+
+	content := bytes.Repeat([]byte("0123456789"), (2*clamdChunkSize+1000)/10)
+	result, err := clamd.Scan(context.Background(), bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if result.Infected || result.Scanner != "clamd" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	sizes := <-fake.chunks
+	want := []int{clamdChunkSize, clamdChunkSize, len(content) - 2*clamdChunkSize}
+	if len(sizes) != len(want) {
+		t.Fatalf("chunks = %v, want %v", sizes, want)
+	}
+	for i := range want {
+		if sizes[i] != want[i] {
+			t.Fatalf("chunks = %v, want %v", sizes, want)
+		}
+	}
+	if got := <-fake.data; !bytes.Equal(got, content) {
+		t.Fatalf("clamd received %d bytes, want %d", len(got), len(content))
+	}
+}
+
+func TestClamdReplies(t *testing.T) {
+	tests := []struct {
+		name      string
+		reply     string
+		infected  bool
+		signature string
+		wantErr   string
+	}{
+		{name: "ok", reply: "stream: OK"},
+		{name: "found", reply: "stream: Eicar-Signature FOUND", infected: true, signature: "Eicar-Signature"},
+		{name: "error", reply: "INSTREAM size limit exceeded. ERROR", wantErr: "size limit exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeClamd(t, "tcp", func([]byte) string { return tt.reply })
+			result, err := NewClamd(fake.address(), time.Second).Scan(context.Background(), strings.NewReader("hello"))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Scan: %v", err)
+			}
+			if result.Infected != tt.infected || result.Signature != tt.signature {
+				t.Fatalf("result = %+v", result)
+			}
+		})
+	}
+}
+
+func TestClamdEmptyStream(t *testing.T) {
+	fake := newFakeClamd(t, "tcp", func([]byte) string { return "stream: OK" })
+	if _, err := NewClamd(fake.address(), time.Second).Scan(context.Background(), strings.NewReader("")); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if sizes := <-fake.chunks; len(sizes) != 0 {
+		t.Fatalf("chunks = %v, want none", sizes)
+	}
+}
+
+func TestClamdTimeout(t *testing.T) {
+	// 接收全部数据后不回复
+	fake := newFakeClamd(t, "tcp", func([]byte) string { return "" })
+	clamd := NewClamd(fake.address(), 200*time.Millisecond)
+
+	start := time.Now()
+	_, err := clamd.Scan(context.Background(), strings.NewReader("hello"))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("err = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Scan took %s", elapsed)
+	}
+}
+
+func TestClamdContextDeadline(t *testing.T) {
+	fake := newFakeClamd(t, "tcp", func([]byte) string { return "" })
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := NewClamd(fake.address(), time.Minute).Scan(ctx, strings.NewReader("hello")); err == nil {
+		t.Fatal("Scan succeeded after the context deadline")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Scan took %s", elapsed)
+	}
+}
+
+func TestClamdUnixSocketAndPing(t *testing.T) {
+	fake := newFakeClamd(t, "unix", func([]byte) string { return "stream: OK" })
+	clamd := NewClamd("unix://"+fake.ln.Addr().String(), time.Second)
+	if err := clamd.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+}
+
+func TestClamdUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := "tcp://" + ln.Addr().String()
+	ln.Close()
+
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hook := Hook(NewClamd(address, time.Second), NewQuarantine(t.TempDir()), time.Second)
+	err = hook(path, &upload.File{ID: "a", Name: "a.txt"})
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("err = %v, want ErrUnavailable", err)
+	}
+}
@@ -0,0 +1,130 @@
+package scan
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/qinchy/hellogo/pkg/upload"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotFound 隔离区中没有该文件
+var ErrNotFound = errors.New("quarantined file not found")
+
+// Entry 隔离区中的一个文件
+type Entry struct {
+	File          upload.File `json:"file"`
+	Result        Result      `json:"result"`
+	QuarantinedAt time.Time   `json:"quarantined_at"`
+}
+
+// Quarantine 感染文件的隔离区，每个文件保存为<id>.bin和<id>.json
+type Quarantine struct {
+	dir string
+}
+
+// NewQuarantine 创建隔离区
+func NewQuarantine(dir string) *Quarantine {
+	return &Quarantine{dir: dir}
+}
+
+// Put 把感染的文件放入隔离区
+func (q *Quarantine) Put(r io.Reader, f *upload.File, result Result) (*Entry, error) {
+	if err := os.MkdirAll(q.dir, 0700); err != nil {
+		return nil, err
+	}
+	entry := &Entry{File: *f, Result: result, QuarantinedAt: time.Now()}
+
+	dst, err := os.OpenFile(q.binPath(f.ID), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(dst, r); err != nil {
+		dst.Close()
+		return nil, err
+	}
+	if err := dst.Close(); err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return entry, os.WriteFile(q.infoPath(f.ID), data, 0600)
+}
+
+// List 按隔离时间倒序列出全部文件
+func (q *Quarantine) List() ([]*Entry, error) {
+	paths, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*Entry, 0, len(paths))
+	for _, path := range paths {
+		e, err := q.Get(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].QuarantinedAt.After(entries[j].QuarantinedAt)
+	})
+	return entries, nil
+}
+
+// Get 查询隔离的文件
+func (q *Quarantine) Get(id string) (*Entry, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(q.infoPath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Open 打开隔离的文件内容
+func (q *Quarantine) Open(id string) (*os.File, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	return os.Open(q.binPath(id))
+}
+
+// Delete 删除隔离的文件
+func (q *Quarantine) Delete(id string) error {
+	if _, err := q.Get(id); err != nil {
+		return err
+	}
+	os.Remove(q.binPath(id))
+	return os.Remove(q.infoPath(id))
+}
+
+func (q *Quarantine) binPath(id string) string {
+	return filepath.Join(q.dir, id+".bin")
+}
+
+func (q *Quarantine) infoPath(id string) string {
+	return filepath.Join(q.dir, id+".json")
+}
+
+// validID 隔离区中的ID即上传文件ID，只能是十六进制串
+func validID(id string) bool {
+	_, err := hex.DecodeString(id)
+	return id != "" && err == nil
+}
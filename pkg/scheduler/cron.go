@@ -1,8 +1,19 @@
 package scheduler
 
+import "time"
+
 func PrintTimeEveryMinute() {
 	//for true {
 	//	fmt.Println(time.Now())
 	//	time.Sleep(1 * time.Second)
 	//}
 }
+
+// Every 每隔interval执行一次job，需要在协程中调用
+func Every(interval time.Duration, job func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		job()
+	}
+}
@@ -0,0 +1,104 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// maxCookieSize 浏览器对单个cookie的大小限制
+const maxCookieSize = 4096
+
+// CookieStore 会话数据全部保存在cookie中：先用AES-GCM加密，再对cookie名和密文计算HMAC。
+// 服务端不保存状态，因此无法在过期前单独撤销某个会话
+type CookieStore struct {
+	name   string
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// NewCookieStore 创建cookie存储，secret至少32字节，加密和签名的密钥由它派生
+func NewCookieStore(name string, secret []byte) (*CookieStore, error) {
+	if len(secret) < 32 {
+		return nil, errors.New("session: cookie secret must be at least 32 bytes")
+	}
+	block, err := aes.NewCipher(derive(secret, "encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &CookieStore{name: name, aead: aead, macKey: derive(secret, "authentication")}, nil
+}
+
+func (cs *CookieStore) Load(value string) (*Session, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) < cs.aead.NonceSize()+sha256.Size {
+		return nil, ErrNotFound
+	}
+	blob, mac := raw[:len(raw)-sha256.Size], raw[len(raw)-sha256.Size:]
+	if !hmac.Equal(mac, cs.mac(blob)) {
+		return nil, ErrNotFound
+	}
+	nonce, ciphertext := blob[:cs.aead.NonceSize()], blob[cs.aead.NonceSize():]
+	data, err := cs.aead.Open(nil, nonce, ciphertext, []byte(cs.name))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, ErrNotFound
+	}
+	if s.Values == nil {
+		s.Values = map[string]string{}
+	}
+	return &s, nil
+}
+
+func (cs *CookieStore) Save(s *Session) (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, cs.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	blob := cs.aead.Seal(nonce, nonce, data, []byte(cs.name))
+	value := base64.RawURLEncoding.EncodeToString(append(blob, cs.mac(blob)...))
+	if len(cs.name)+len(value)+1 > maxCookieSize {
+		return "", ErrTooLarge
+	}
+	return value, nil
+}
+
+// Delete cookie存储没有服务端状态，清除cookie即可
+func (cs *CookieStore) Delete(id string) error {
+	return nil
+}
+
+func (cs *CookieStore) Cleanup(expired func(s *Session) bool) (int, error) {
+	return 0, nil
+}
+
+func (cs *CookieStore) mac(blob []byte) []byte {
+	m := hmac.New(sha256.New, cs.macKey)
+	m.Write([]byte(cs.name))
+	m.Write([]byte{0})
+	m.Write(blob)
+	return m.Sum(nil)
+}
+
+// derive 从secret派生指定用途的32字节密钥
+func derive(secret []byte, purpose string) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte("hellogo session " + purpose))
+	return m.Sum(nil)
+}
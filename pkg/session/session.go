@@ -0,0 +1,245 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound 会话不存在，或者cookie无法解密、签名不正确
+	ErrNotFound = errors.New("session not found")
+	// ErrTooLarge 会话数据超过cookie的大小限制
+	ErrTooLarge = errors.New("session too large for cookie")
+)
+
+// touchInterval 距离上次访问超过该时间才更新最后访问时间，避免每个请求都写存储
+const touchInterval = time.Minute
+
+// Session 一个会话，值只支持字符串
+type Session struct {
+	ID        string            `json:"id"`
+	Values    map[string]string `json:"values"`
+	CreatedAt time.Time         `json:"created_at"`
+	LastSeen  time.Time         `json:"last_seen"`
+
+	// oldID 重新生成ID前的ID，保存时从存储中删除
+	oldID     string
+	modified  bool
+	destroyed bool
+}
+
+// Get 获取值，不存在时返回空字符串
+func (s *Session) Get(key string) string {
+	return s.Values[key]
+}
+
+// Set 设置值
+func (s *Session) Set(key, value string) {
+	if s.Values[key] == value {
+		return
+	}
+	s.Values[key] = value
+	s.modified = true
+}
+
+// Delete 删除值
+func (s *Session) Delete(key string) {
+	if _, ok := s.Values[key]; ok {
+		delete(s.Values, key)
+		s.modified = true
+	}
+}
+
+// Regenerate 更换会话ID并保留数据，登录等权限变化时调用，防止会话固定攻击
+func (s *Session) Regenerate() error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	if s.oldID == "" {
+		s.oldID = s.ID
+	}
+	s.ID = id
+	s.modified = true
+	return nil
+}
+
+// Destroy 删除会话并清除cookie
+func (s *Session) Destroy() {
+	s.destroyed = true
+}
+
+// Store 会话存储
+type Store interface {
+	// Load 根据cookie的值加载会话
+	Load(value string) (*Session, error)
+	// Save 保存会话，返回写入cookie的值
+	Save(s *Session) (string, error)
+	// Delete 删除会话
+	Delete(id string) error
+	// Cleanup 删除过期的会话，返回删除的数量
+	Cleanup(expired func(s *Session) bool) (int, error)
+}
+
+// Options 会话cookie和超时配置
+type Options struct {
+	CookieName string
+	Domain     string
+	Path       string
+	Secure     bool
+	SameSite   http.SameSite
+	// IdleTimeout 超过该时间没有访问时会话过期
+	IdleTimeout time.Duration
+	// AbsoluteTimeout 从创建开始无论是否访问，超过该时间会话过期
+	AbsoluteTimeout time.Duration
+}
+
+// ParseSameSite 解析SameSite配置：lax、strict、none，为空时使用lax
+func ParseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(s) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, errors.New("invalid SameSite: " + s)
+}
+
+// Manager 从请求的cookie加载会话，处理超时并把会话写回cookie
+type Manager struct {
+	store Store
+	opts  Options
+}
+
+// New 创建Manager
+func New(store Store, opts Options) *Manager {
+	if opts.CookieName == "" {
+		opts.CookieName = "hellogo_session"
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = 30 * time.Minute
+	}
+	if opts.AbsoluteTimeout <= 0 {
+		opts.AbsoluteTimeout = 12 * time.Hour
+	}
+	return &Manager{store: store, opts: opts}
+}
+
+// Load 加载请求的会话，没有cookie、会话不存在或已过期时返回新会话
+func (m *Manager) Load(r *http.Request) (*Session, error) {
+	if cookie, err := r.Cookie(m.opts.CookieName); err == nil && cookie.Value != "" {
+		s, err := m.store.Load(cookie.Value)
+		switch {
+		case err == nil && !m.expired(s):
+			if time.Since(s.LastSeen) > touchInterval {
+				s.LastSeen = time.Now()
+				s.modified = true
+			}
+			return s, nil
+		case err == nil:
+			if err := m.store.Delete(s.ID); err != nil {
+				return nil, err
+			}
+		case !errors.Is(err, ErrNotFound):
+			return nil, err
+		}
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	// 新会话在写入值之前不保存，避免为每个匿名请求创建会话
+	return &Session{ID: id, Values: map[string]string{}, CreatedAt: now, LastSeen: now}, nil
+}
+
+// Save 会话有变化时保存并写入cookie，已销毁的会话删除并清除cookie
+func (m *Manager) Save(w http.ResponseWriter, s *Session) error {
+	if s.destroyed {
+		if s.oldID != "" {
+			m.store.Delete(s.oldID)
+		}
+		if err := m.store.Delete(s.ID); err != nil {
+			return err
+		}
+		http.SetCookie(w, m.cookie("", -1))
+		return nil
+	}
+	if !s.modified {
+		return nil
+	}
+
+	value, err := m.store.Save(s)
+	if err != nil {
+		return err
+	}
+	if s.oldID != "" {
+		if err := m.store.Delete(s.oldID); err != nil {
+			return err
+		}
+		s.oldID = ""
+	}
+	s.modified = false
+
+	// cookie在会话过期时同时失效
+	expiry := s.LastSeen.Add(m.opts.IdleTimeout)
+	if absolute := s.CreatedAt.Add(m.opts.AbsoluteTimeout); absolute.Before(expiry) {
+		expiry = absolute
+	}
+	http.SetCookie(w, m.cookie(value, int(time.Until(expiry).Seconds())))
+	return nil
+}
+
+// Cleanup 删除存储中已过期的会话
+func (m *Manager) Cleanup() (int, error) {
+	return m.store.Cleanup(m.expired)
+}
+
+func (m *Manager) expired(s *Session) bool {
+	now := time.Now()
+	return now.After(s.LastSeen.Add(m.opts.IdleTimeout)) || now.After(s.CreatedAt.Add(m.opts.AbsoluteTimeout))
+}
+
+func (m *Manager) cookie(value string, maxAge int) *http.Cookie {
+	if maxAge == 0 {
+		maxAge = -1
+	}
+	return &http.Cookie{
+		Name:     m.opts.CookieName,
+		Value:    value,
+		Path:     m.opts.Path,
+		Domain:   m.opts.Domain,
+		MaxAge:   maxAge,
+		Secure:   m.opts.Secure,
+		HttpOnly: true,
+		SameSite: m.opts.SameSite,
+	}
+}
+
+// newID 随机生成43个字符的会话ID
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// validID 会话ID会作为文件名，只接受newID生成的格式
+func validID(id string) bool {
+	if len(id) != 43 {
+		return false
+	}
+	_, err := base64.RawURLEncoding.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,161 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// MemoryStore 保存在内存中的会话，重启后全部失效
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: map[string]Session{}}
+}
+
+func (ms *MemoryStore) Load(value string) (*Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	s, ok := ms.sessions[value]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clone(&s), nil
+}
+
+func (ms *MemoryStore) Save(s *Session) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.sessions[s.ID] = *clone(s)
+	return s.ID, nil
+}
+
+func (ms *MemoryStore) Delete(id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.sessions, id)
+	return nil
+}
+
+func (ms *MemoryStore) Cleanup(expired func(s *Session) bool) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	removed := 0
+	for id, s := range ms.sessions {
+		if expired(&s) {
+			delete(ms.sessions, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// FileStore 每个会话保存为目录中的<id>.json
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore 创建文件存储
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (fs *FileStore) Load(value string) (*Session, error) {
+	if !validID(value) {
+		return nil, ErrNotFound
+	}
+	return fs.read(fs.path(value))
+}
+
+func (fs *FileStore) Save(s *Session) (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(fs.dir, "tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	return s.ID, os.Rename(tmp.Name(), fs.path(s.ID))
+}
+
+func (fs *FileStore) Delete(id string) error {
+	if !validID(id) {
+		return nil
+	}
+	if err := os.Remove(fs.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (fs *FileStore) Cleanup(expired func(s *Session) bool) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(fs.dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, path := range paths {
+		s, err := fs.read(path)
+		// 无法解析的文件同样删除
+		if err == nil && !expired(s) {
+			continue
+		}
+		if os.Remove(path) == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (fs *FileStore) read(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if s.ID != strings.TrimSuffix(filepath.Base(path), ".json") {
+		return nil, ErrNotFound
+	}
+	if s.Values == nil {
+		s.Values = map[string]string{}
+	}
+	return &s, nil
+}
+
+func (fs *FileStore) path(id string) string {
+	return filepath.Join(fs.dir, id+".json")
+}
+
+// clone 复制会话数据，存储中的会话和请求中的会话互不影响
+func clone(s *Session) *Session {
+	values := make(map[string]string, len(s.Values))
+	for k, v := range s.Values {
+		values[k] = v
+	}
+	return &Session{ID: s.ID, Values: values, CreatedAt: s.CreatedAt, LastSeen: s.LastSeen}
+}
@@ -0,0 +1,264 @@
+package sniff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// HeadSize 嗅探类型时读取的字节数，zip需要看到后面几个条目才能区分office文档
+const HeadSize = 8 << 10
+
+// 常用类型
+const (
+	OctetStream = "application/octet-stream"
+	Zip         = "application/zip"
+	OLE         = "application/x-ole-storage"
+	Docx        = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	Xlsx        = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	Pptx        = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+)
+
+// signature 文件头魔数
+type signature struct {
+	offset int
+	magic  []byte
+	typ    string
+}
+
+// signatures http.DetectContentType不认识或识别不准的格式
+var signatures = []signature{
+	{0, []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}, OLE},
+	{0, []byte{0x28, 0xb5, 0x2f, 0xfd}, "application/zstd"},
+	{0, []byte("BZh"), "application/x-bzip2"},
+	{0, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}, "application/x-7z-compressed"},
+	{0, []byte("\x7fELF"), "application/x-executable"},
+	{0, []byte("MZ"), "application/x-msdownload"},
+	{0, []byte("#!"), "text/x-shellscript"},
+	{4, []byte("ftypheic"), "image/heic"},
+}
+
+// extensionTypes mime包中可能缺失的扩展名
+var extensionTypes = map[string]string{
+	".doc":  "application/msword",
+	".xls":  "application/vnd.ms-excel",
+	".ppt":  "application/vnd.ms-powerpoint",
+	".docx": Docx,
+	".xlsx": Xlsx,
+	".pptx": Pptx,
+	".csv":  "text/csv",
+	".md":   "text/markdown",
+	".yaml": "application/x-yaml",
+	".yml":  "application/x-yaml",
+	".zst":  "application/zstd",
+	".bz2":  "application/x-bzip2",
+	".7z":   "application/x-7z-compressed",
+	".heic": "image/heic",
+	".sh":   "text/x-shellscript",
+	".exe":  "application/x-msdownload",
+	".dll":  "application/x-msdownload",
+}
+
+// Detect 根据内容开头的字节判断真实类型，返回不带参数的MIME类型
+func Detect(head []byte) string {
+	for _, sig := range signatures {
+		if len(head) >= sig.offset+len(sig.magic) && bytes.Equal(head[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			return sig.typ
+		}
+	}
+	typ := baseType(http.DetectContentType(head))
+	if typ == Zip {
+		return detectZip(head)
+	}
+	return typ
+}
+
+// DetectReader 读取r开头的字节判断类型，返回类型以及可以从头读取完整内容的reader
+func DetectReader(r io.Reader) (string, io.Reader, error) {
+	head := make([]byte, HeadSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+	head = head[:n]
+	return Detect(head), io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+// detectZip office的OOXML文档是zip，通过其中的目录名区分
+func detectZip(head []byte) string {
+	switch {
+	case bytes.Contains(head, []byte("word/")):
+		return Docx
+	case bytes.Contains(head, []byte("xl/")):
+		return Xlsx
+	case bytes.Contains(head, []byte("ppt/")):
+		return Pptx
+	}
+	return Zip
+}
+
+// TypeByExtension 扩展名对应的MIME类型，未知时返回空字符串
+func TypeByExtension(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if typ, ok := extensionTypes[ext]; ok {
+		return typ
+	}
+	return baseType(mime.TypeByExtension(ext))
+}
+
+// Compatible 嗅探出的类型与声明的类型是否一致
+func Compatible(detected, claimed string) bool {
+	detected, claimed = baseType(detected), baseType(claimed)
+	switch {
+	case claimed == "" || claimed == detected:
+		return true
+	case detected == "text/plain":
+		// 纯文本无法进一步区分，只要求声明的也是文本类型
+		return textual(claimed)
+	case detected == "text/xml" || detected == "application/xml":
+		return claimed == "text/xml" || claimed == "application/xml" || strings.HasSuffix(claimed, "+xml")
+	case detected == Zip:
+		// jar、apk、epub、odt等都是zip
+		return claimed == "application/x-zip-compressed" ||
+			claimed == "application/java-archive" ||
+			claimed == "application/vnd.android.package-archive" ||
+			strings.HasSuffix(claimed, "+zip") ||
+			strings.HasPrefix(claimed, "application/vnd.oasis.opendocument.") ||
+			strings.HasPrefix(claimed, "application/vnd.openxmlformats-officedocument.")
+	case detected == OLE:
+		return claimed == "application/msword" || claimed == "application/vnd.ms-excel" || claimed == "application/vnd.ms-powerpoint"
+	case detected == "application/x-gzip":
+		return claimed == "application/gzip"
+	}
+	return false
+}
+
+// Resolve 扩展名的类型与内容一致时使用更具体的扩展名类型，比如text/plain的.csv文件为text/csv
+func Resolve(detected, filename string) string {
+	if fromExt := TypeByExtension(filename); fromExt != "" && fromExt != detected && Compatible(detected, fromExt) {
+		return fromExt
+	}
+	return detected
+}
+
+// textual 是否为文本类型
+func textual(typ string) bool {
+	if strings.HasPrefix(typ, "text/") || strings.HasSuffix(typ, "+xml") || strings.HasSuffix(typ, "+json") {
+		return true
+	}
+	switch typ {
+	case "application/json", "application/xml", "application/javascript", "application/x-yaml", "application/x-ndjson":
+		return true
+	}
+	return false
+}
+
+// baseType 去掉MIME类型中的参数
+func baseType(typ string) string {
+	if typ == "" {
+		return ""
+	}
+	if t, _, err := mime.ParseMediaType(typ); err == nil {
+		return t
+	}
+	return strings.ToLower(strings.TrimSpace(strings.SplitN(typ, ";", 2)[0]))
+}
+
+// Error 类型检查失败的结构化错误
+type Error struct {
+	Code      string `json:"code"`
+	Message   string `json:"error"`
+	Detected  string `json:"detected"`
+	Declared  string `json:"declared,omitempty"`
+	Extension string `json:"extension,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Policy 单个路由的类型策略，类型支持 "image/*" 形式的通配
+type Policy struct {
+	// Allow 为空时允许所有类型
+	Allow []string
+	// Deny 优先于Allow
+	Deny []string
+	// Strict 为true时要求真实类型与扩展名、声明的Content-Type一致
+	Strict bool
+}
+
+// ParseList 解析逗号分隔的类型列表
+func ParseList(s string) []string {
+	var list []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			list = append(list, t)
+		}
+	}
+	return list
+}
+
+// Check 嗅探内容开头的字节并按策略检查，返回保存时使用的类型
+func (p Policy) Check(head []byte, filename, declared string) (string, error) {
+	detected := Detect(head)
+	if err := p.Verify(detected, filename, declared); err != nil {
+		return detected, err
+	}
+	return Resolve(detected, filename), nil
+}
+
+// Verify 按策略检查已经嗅探出的类型
+func (p Policy) Verify(detected, filename, declared string) error {
+	fromExt := TypeByExtension(filename)
+	declared = baseType(declared)
+	if declared == OctetStream {
+		// 大多数客户端不认识类型时都声明为octet-stream，不作为依据
+		declared = ""
+	}
+
+	newErr := func(code, format string, args ...interface{}) error {
+		return &Error{
+			Code:      code,
+			Message:   fmt.Sprintf(format, args...),
+			Detected:  detected,
+			Declared:  declared,
+			Extension: filepath.Ext(filename),
+		}
+	}
+
+	if match(p.Deny, detected) {
+		return newErr("type_denied", "file type %s is not allowed", detected)
+	}
+	if len(p.Allow) > 0 && !match(p.Allow, detected) {
+		return newErr("type_not_allowed", "file type %s is not in the allowlist", detected)
+	}
+	if p.Strict {
+		if !Compatible(detected, fromExt) {
+			return newErr("extension_mismatch", "file content is %s but extension %s implies %s", detected, filepath.Ext(filename), fromExt)
+		}
+		if !Compatible(detected, declared) {
+			return newErr("content_type_mismatch", "file content is %s but Content-Type is %s", detected, declared)
+		}
+	}
+	return nil
+}
+
+// match 类型是否命中列表
+func match(patterns []string, typ string) bool {
+	for _, p := range patterns {
+		if p == "*" || p == "*/*" || p == typ {
+			return true
+		}
+		if strings.HasSuffix(p, "/*") && strings.HasPrefix(typ, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
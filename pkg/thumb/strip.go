@@ -0,0 +1,263 @@
+package thumb
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Strip 去除图片中的EXIF等元数据（拍摄位置、设备信息、注释），不重新编码图像数据
+//
+//	JPEG：去除APP1（EXIF、XMP）、APP3~APP13、APP15和注释段，保留JFIF、ICC配置和Adobe段；
+//	      EXIF方向不为1时改写为只含方向标签的EXIF段，图片仍按拍摄方向显示
+//	PNG：去除eXIf、tEXt、zTXt、iTXt和tIME块
+//	GIF：去除注释扩展以及NETSCAPE循环以外的应用扩展（如XMP）
+func Strip(data []byte, contentType string) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/gif":
+		return stripGIF(data)
+	}
+	return data, nil
+}
+
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, ErrInvalidImage
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	oriented := false
+	i := 2
+	for {
+		// 段之间可能有填充的0xFF
+		for i < len(data) && data[i] == 0xFF && i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+1 >= len(data) || data[i] != 0xFF {
+			return nil, ErrInvalidImage
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xD9: // EOI
+			return append(out, data[i:i+2]...), nil
+		case marker == 0x01 || marker >= 0xD0 && marker <= 0xD7: // 没有长度的段
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			return nil, ErrInvalidImage
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return nil, ErrInvalidImage
+		}
+		if marker == 0xDA {
+			// SOS之后是熵编码数据，直到EOI原样保留
+			return append(out, data[i:]...), nil
+		}
+		switch {
+		case !jpegMetadata(marker):
+			out = append(out, data[i:end]...)
+		case marker == 0xE1 && !oriented && bytes.HasPrefix(data[i+4:end], exifHeader):
+			oriented = true
+			if o := exifOrientation(data[i+10 : end]); o != 1 {
+				out = append(out, orientationSegment(o)...)
+			}
+		}
+		i = end
+	}
+}
+
+// jpegMetadata 是否为需要去除的元数据段
+func jpegMetadata(marker byte) bool {
+	switch {
+	case marker == 0xE0, marker == 0xE2, marker == 0xEE: // JFIF、ICC、Adobe
+		return false
+	case marker >= 0xE1 && marker <= 0xEF, marker == 0xFE: // APPn、COM
+		return true
+	}
+	return false
+}
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// orientationSegment 只含方向标签（0x0112）的APP1 EXIF段
+func orientationSegment(o int) []byte {
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // 大端序，IFD0偏移8
+		0x00, 0x01, // 1个标签
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, byte(o), 0x00, 0x00, // 方向，SHORT，1个
+		0x00, 0x00, 0x00, 0x00, // 没有下一个IFD
+	}
+	seg := []byte{0xFF, 0xE1, 0x00, 0x00}
+	binary.BigEndian.PutUint16(seg[2:], uint16(2+len(exifHeader)+len(tiff)))
+	seg = append(seg, exifHeader...)
+	return append(seg, tiff...)
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, ErrInvalidImage
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	i := len(pngSignature)
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, ErrInvalidImage
+		}
+		// 长度(4) 类型(4) 数据 CRC(4)
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i+12 {
+			return nil, ErrInvalidImage
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out = append(out, data[i:end]...)
+		}
+		if string(data[i+4:i+8]) == "IEND" {
+			return out, nil
+		}
+		i = end
+	}
+	return nil, ErrInvalidImage
+}
+
+func stripGIF(data []byte) ([]byte, error) {
+	if len(data) < 13 || !(bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a"))) {
+		return nil, ErrInvalidImage
+	}
+	// 文件头(6) 逻辑屏幕描述符(7) 全局颜色表
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << ((data[10] & 0x07) + 1)
+	}
+	if i > len(data) {
+		return nil, ErrInvalidImage
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:i]...)
+	for i < len(data) {
+		switch data[i] {
+		case 0x3B: // 结束
+			return append(out, 0x3B), nil
+		case 0x21: // 扩展
+			if i+2 > len(data) {
+				return nil, ErrInvalidImage
+			}
+			end, err := skipSubBlocks(data, i+2)
+			if err != nil {
+				return nil, err
+			}
+			if keepGIFExtension(data[i+1], data[i+2:end]) {
+				out = append(out, data[i:end]...)
+			}
+			i = end
+		case 0x2C: // 图像描述符(10) 局部颜色表 LZW最小码长(1) 图像数据
+			if i+11 > len(data) {
+				return nil, ErrInvalidImage
+			}
+			start := i + 10
+			if data[i+9]&0x80 != 0 {
+				start += 3 << ((data[i+9] & 0x07) + 1)
+			}
+			end, err := skipSubBlocks(data, start+1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, data[i:end]...)
+			i = end
+		default:
+			return nil, ErrInvalidImage
+		}
+	}
+	// 缺少结束符的GIF很常见，补上
+	return append(out, 0x3B), nil
+}
+
+// keepGIFExtension 保留图形控制、纯文本扩展和动画循环次数
+func keepGIFExtension(label byte, blocks []byte) bool {
+	switch label {
+	case 0xF9, 0x01:
+		return true
+	case 0xFF:
+		return len(blocks) >= 12 && (string(blocks[1:12]) == "NETSCAPE2.0" || string(blocks[1:12]) == "ANIMEXTS1.0")
+	}
+	return false
+}
+
+// skipSubBlocks 跳过从i开始的数据子块序列（以长度为0的块结束），返回结束后的位置
+func skipSubBlocks(data []byte, i int) (int, error) {
+	for {
+		if i >= len(data) {
+			return 0, ErrInvalidImage
+		}
+		n := int(data[i])
+		i++
+		if n == 0 {
+			return i, nil
+		}
+		i += n
+	}
+}
+
+// orientation 读取JPEG中EXIF的方向标签（0x0112），没有时返回1
+func orientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || end > len(data) || end < i+4 {
+			break
+		}
+		if marker == 0xE1 && bytes.HasPrefix(data[i+4:end], exifHeader) {
+			return exifOrientation(data[i+10 : end])
+		}
+		i = end
+	}
+	return 1
+}
+
+// exifOrientation 在TIFF结构的IFD0中查找方向标签
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) || ifd < 8 {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+	return 1
+}
@@ -0,0 +1,218 @@
+package thumb
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidImage 内容不是可解析的PNG、JPEG或GIF图片
+	ErrInvalidImage = errors.New("invalid image")
+
+	// ErrTooManyPixels 图片像素数超过上限，不生成缩略图以免解码时占用过多内存
+	ErrTooManyPixels = errors.New("image has too many pixels")
+)
+
+// jpegQuality JPEG缩略图的编码质量
+const jpegQuality = 85
+
+// Info 图片信息，宽高为按EXIF方向校正后的显示尺寸
+type Info struct {
+	Width       int
+	Height      int
+	Orientation int
+}
+
+// Supported 是否支持为该类型的图片生成缩略图
+func Supported(contentType string) bool {
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	}
+	return false
+}
+
+// ContentType 缩略图的类型：JPEG仍为JPEG，PNG和GIF输出PNG以保留透明度
+func ContentType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// Ext 缩略图的扩展名
+func Ext(contentType string) string {
+	if ContentType(contentType) == "image/jpeg" {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+// ParseSizes 解析逗号分隔的缩略图尺寸，如 "128,256,512"，忽略无效值并升序去重
+func ParseSizes(s string) []int {
+	seen := map[int]bool{}
+	var sizes []int
+	for _, item := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || n <= 0 || seen[n] {
+			continue
+		}
+		seen[n] = true
+		sizes = append(sizes, n)
+	}
+	sort.Ints(sizes)
+	return sizes
+}
+
+// Inspect 只解析图片头部，返回显示尺寸
+func Inspect(data []byte) (Info, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Info{}, ErrInvalidImage
+	}
+	info := Info{Width: cfg.Width, Height: cfg.Height, Orientation: orientation(data)}
+	if info.Orientation >= 5 {
+		// 5~8 需要旋转90度，宽高互换
+		info.Width, info.Height = info.Height, info.Width
+	}
+	return info, nil
+}
+
+// Generate 按尺寸生成缩略图，缩略图的长边不超过尺寸且不放大原图。
+// data为原始内容或Strip后的内容，两者都带有EXIF方向，以便按拍摄方向校正
+func Generate(data []byte, contentType string, sizes []int, maxPixels int64) (map[int][]byte, error) {
+	info, err := Inspect(data)
+	if err != nil {
+		return nil, err
+	}
+	if maxPixels > 0 && int64(info.Width)*int64(info.Height) > maxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	// 统一转换为RGBA，缩放时直接读写像素数组
+	rgba := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, src.Bounds().Min, draw.Src)
+
+	thumbs := make(map[int][]byte, len(sizes))
+	for _, size := range sizes {
+		w, h := fit(rgba.Bounds().Dx(), rgba.Bounds().Dy(), size)
+		img := orient(Resize(rgba, w, h), info.Orientation)
+
+		var buf bytes.Buffer
+		if ContentType(contentType) == "image/jpeg" {
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+		} else {
+			err = png.Encode(&buf, img)
+		}
+		if err != nil {
+			return nil, err
+		}
+		thumbs[size] = buf.Bytes()
+	}
+	return thumbs, nil
+}
+
+// fit 按比例缩放到长边不超过size，不放大
+func fit(w, h, size int) (int, int) {
+	if w <= size && h <= size {
+		return w, h
+	}
+	if w >= h {
+		return size, max(1, h*size/w)
+	}
+	return max(1, w*size/h), size
+}
+
+// Resize 按区域平均（box filter）缩小图片，缩小时每个目标像素取对应源区域所有像素的平均值
+func Resize(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for dy := 0; dy < h; dy++ {
+		y0, y1 := span(dy, h, sh)
+		for dx := 0; dx < w; dx++ {
+			x0, x1 := span(dx, w, sw)
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride+x0*4 : y*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					b += uint64(row[i+2])
+					a += uint64(row[i+3])
+					n++
+				}
+			}
+			i := dy*dst.Stride + dx*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// span 目标坐标d对应的源坐标区间[from, to)，区间至少包含一个像素
+func span(d, dstLen, srcLen int) (int, int) {
+	from := d * srcLen / dstLen
+	to := (d + 1) * srcLen / dstLen
+	if to <= from {
+		to = from + 1
+	}
+	return from, to
+}
+
+// orient 按EXIF方向（1~8）旋转、翻转图片
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var nx, ny int
+			switch orientation {
+			case 2: // 水平翻转
+				nx, ny = w-1-x, y
+			case 3: // 旋转180度
+				nx, ny = w-1-x, h-1-y
+			case 4: // 垂直翻转
+				nx, ny = x, h-1-y
+			case 5: // 沿左上-右下对角线翻转
+				nx, ny = y, x
+			case 6: // 顺时针旋转90度
+				nx, ny = h-1-y, x
+			case 7: // 沿右上-左下对角线翻转
+				nx, ny = h-1-y, w-1-x
+			case 8: // 逆时针旋转90度
+				nx, ny = y, w-1-x
+			}
+			copy(dst.Pix[ny*dst.Stride+nx*4:ny*dst.Stride+nx*4+4], src.Pix[y*src.Stride+x*4:y*src.Stride+x*4+4])
+		}
+	}
+	return dst
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
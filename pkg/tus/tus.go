@@ -0,0 +1,369 @@
+package tus
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version 支持的tus协议版本
+const Version = "1.0.0"
+
+// Extensions 支持的tus扩展
+const Extensions = "creation,termination,expiration"
+
+var (
+	// ErrNotFound 上传不存在
+	ErrNotFound = errors.New("upload not found")
+
+	// ErrExpired 上传已过期
+	ErrExpired = errors.New("upload expired")
+
+	// ErrOffsetMismatch 请求的偏移量与服务端不一致
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+
+	// ErrTooLarge 上传长度超过限制或写入超过声明的长度
+	ErrTooLarge = errors.New("upload exceeds length")
+
+	// ErrLocked 同一上传正在被另一个请求写入
+	ErrLocked = errors.New("upload is locked by another request")
+
+	// ErrCompleted 上传已完成，不能再写入
+	ErrCompleted = errors.New("upload already completed")
+)
+
+// Info 一次断点续传上传的状态，保存在<id>.json中，数据保存在<id>.bin中
+type Info struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata"`
+	Uploader  string            `json:"uploader"`
+	Stored    string            `json:"stored,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Done 数据是否已全部接收
+func (i *Info) Done() bool {
+	return i.Offset == i.Length
+}
+
+// Pending 数据已全部接收但还没有转存成功
+func (i *Info) Pending() bool {
+	return i.Done() && i.Stored == ""
+}
+
+// Store 保存未完成上传的磁盘存储
+type Store struct {
+	dir     string
+	maxSize int64
+	expiry  time.Duration
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// NewStore 创建存储，maxSize为0表示不限制大小
+func NewStore(dir string, maxSize int64, expiry time.Duration) *Store {
+	return &Store{dir: dir, maxSize: maxSize, expiry: expiry, locks: map[string]*sync.Mutex{}}
+}
+
+// MaxSize 允许的最大上传长度
+func (s *Store) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Create 创建一个新的上传
+func (s *Store) Create(length int64, metadata map[string]string, uploader string) (*Info, error) {
+	if length < 0 {
+		return nil, fmt.Errorf("invalid upload length %d", length)
+	}
+	if s.maxSize > 0 && length > s.maxSize {
+		return nil, fmt.Errorf("%w: length %d, limit %d", ErrTooLarge, length, s.maxSize)
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	info := &Info{
+		ID:        newID(),
+		Length:    length,
+		Metadata:  metadata,
+		Uploader:  uploader,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.expiry),
+	}
+	f, err := os.OpenFile(s.binPath(info.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	if err := s.save(info); err != nil {
+		os.Remove(s.binPath(info.ID))
+		return nil, err
+	}
+	return info, nil
+}
+
+// Get 查询上传状态
+func (s *Store) Get(id string) (*Info, error) {
+	info, err := s.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Done() && time.Now().After(info.ExpiresAt) {
+		return info, ErrExpired
+	}
+	return info, nil
+}
+
+// Append 从offset处追加数据，offset必须等于当前偏移量
+// 连接中断时已写入的部分同样会被记录，客户端可以通过HEAD获取偏移量后续传
+func (s *Store) Append(id string, offset int64, r io.Reader) (*Info, error) {
+	lock := s.lock(id)
+	if !lock.TryLock() {
+		return nil, ErrLocked
+	}
+	defer lock.Unlock()
+
+	info, err := s.Get(id)
+	if err != nil {
+		return info, err
+	}
+	if info.Done() {
+		return info, ErrCompleted
+	}
+	if offset != info.Offset {
+		return info, fmt.Errorf("%w: got %d, expected %d", ErrOffsetMismatch, offset, info.Offset)
+	}
+
+	f, err := os.OpenFile(s.binPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+	// 以实际文件大小为准截断，丢弃上次中断时可能未记录的尾部数据
+	if err := f.Truncate(info.Offset); err != nil {
+		return info, err
+	}
+	if _, err := f.Seek(info.Offset, io.SeekStart); err != nil {
+		return info, err
+	}
+
+	remaining := info.Length - info.Offset
+	n, copyErr := io.Copy(f, io.LimitReader(r, remaining))
+	if copyErr == nil && n == remaining {
+		// 请求体超过声明的长度时丢弃本次写入的全部数据
+		var extra [1]byte
+		if m, _ := r.Read(extra[:]); m > 0 {
+			if err := f.Truncate(info.Offset); err != nil {
+				return info, err
+			}
+			return info, fmt.Errorf("%w: body exceeds remaining %d bytes", ErrTooLarge, remaining)
+		}
+	}
+	info.Offset += n
+
+	info.ExpiresAt = time.Now().Add(s.expiry)
+	if err := s.save(info); err != nil {
+		return info, err
+	}
+	return info, copyErr
+}
+
+// Complete 数据全部接收后调用store转存，store返回转存后的文件ID。
+// 转存成功后删除数据，保留状态以便客户端查询；失败时状态不变，之后的HEAD和PATCH可以重试。
+// 同一上传同时只有一个请求在写入或转存
+func (s *Store) Complete(id string, store func(info *Info, data *os.File) (string, error)) (*Info, error) {
+	lock := s.lock(id)
+	if !lock.TryLock() {
+		return nil, ErrLocked
+	}
+	defer lock.Unlock()
+
+	info, err := s.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Pending() {
+		return info, nil
+	}
+	f, err := os.Open(s.binPath(id))
+	if err != nil {
+		return info, err
+	}
+	stored, err := store(info, f)
+	f.Close()
+	if err != nil {
+		return info, err
+	}
+
+	info.Stored = stored
+	if err := s.save(info); err != nil {
+		info.Stored = ""
+		return info, err
+	}
+	os.Remove(s.binPath(id))
+	return info, nil
+}
+
+// Terminate 终止并删除上传
+func (s *Store) Terminate(id string) error {
+	if _, err := s.load(id); err != nil {
+		return err
+	}
+	s.remove(id)
+	return nil
+}
+
+// Cleanup 删除所有已过期的上传，返回删除的数量
+func (s *Store) Cleanup() (int, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(paths)
+
+	removed := 0
+	now := time.Now()
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+		info, err := s.load(id)
+		if err != nil {
+			continue
+		}
+		if now.After(info.ExpiresAt) {
+			s.remove(id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// ParseMetadata 解析Upload-Metadata头：逗号分隔的 "key base64(value)"
+func ParseMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, fmt.Errorf("invalid metadata %q", pair)
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata value for %s: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// EncodeMetadata 生成Upload-Metadata头
+func EncodeMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if metadata[k] == "" {
+			pairs = append(pairs, k)
+			continue
+		}
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(metadata[k])))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// lock 获取上传对应的锁
+func (s *Store) lock(id string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[id] = l
+	}
+	return l
+}
+
+// load 读取上传状态
+func (s *Store) load(id string) (*Info, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(s.infoPath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// save 通过临时文件原子地保存上传状态
+func (s *Store) save(info *Info) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	tmp := s.infoPath(info.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.infoPath(info.ID))
+}
+
+// remove 删除上传的数据和状态
+func (s *Store) remove(id string) {
+	os.Remove(s.binPath(id))
+	os.Remove(s.infoPath(id))
+	s.mu.Lock()
+	delete(s.locks, id)
+	s.mu.Unlock()
+}
+
+func (s *Store) binPath(id string) string {
+	return filepath.Join(s.dir, id+".bin")
+}
+
+func (s *Store) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// validID ID只能是newID生成的十六进制串，防止路径穿越
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// newID 生成随机ID
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,376 @@
+package upload
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound 文件不存在
+var ErrNotFound = errors.New("file not found")
+
+// File 上传文件的元数据，内容按SHA-256保存在blobs目录中，相同内容只保存一份。
+// Width、Height为图片的显示尺寸，非图片时为0
+type File struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Hash        string `json:"hash"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	// Uploader 上传者，匿名上传时为空
+	Uploader  string    `json:"uploader"`
+	Width     int       `json:"width,omitempty"`
+	Height    int       `json:"height,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Meta 保存文件时由调用方提供的元数据
+type Meta struct {
+	Name        string
+	ContentType string
+	Uploader    string
+	Width       int
+	Height      int
+	// Reviewed 已由管理员人工审核，保存时跳过扫描
+	Reviewed bool
+}
+
+// ScanFunc 文件内容写入临时文件后、对外可见前调用的扫描钩子，返回错误时文件不会被保存
+type ScanFunc func(path string, f *File) error
+
+// Store 内容寻址的上传存储
+//
+//	<root>/blobs/ab/abcdef…  按SHA-256保存的内容
+//	<root>/thumbs/ab/abcdef…/ 按内容哈希保存的缩略图，文件名为尺寸
+//	<root>/tmp/              写入中的临时文件
+//	<root>/index.json        文件ID到元数据的索引
+type Store struct {
+	root string
+	scan ScanFunc
+
+	mu    sync.RWMutex
+	files map[string]*File
+}
+
+// NewStore 创建上传存储并加载索引
+func NewStore(root string) (*Store, error) {
+	s := &Store{root: root, files: map[string]*File{}}
+	for _, dir := range []string{s.blobDir(), s.thumbDir(), s.tmpDir()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(s.indexPath())
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []*File
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		s.files[f.ID] = f
+	}
+	return s, nil
+}
+
+// Root 上传根目录
+func (s *Store) Root() string {
+	return s.root
+}
+
+// SetScanner 设置扫描钩子，需在开始接收上传前调用
+func (s *Store) SetScanner(scan ScanFunc) {
+	s.scan = scan
+}
+
+// Save 保存multipart表单中的文件
+func (s *Store) Save(fh *multipart.FileHeader, meta Meta) (*File, error) {
+	src, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	if meta.Name == "" {
+		meta.Name = fh.Filename
+	}
+	if meta.ContentType == "" {
+		meta.ContentType = fh.Header.Get("Content-Type")
+	}
+	return s.Put(src, meta)
+}
+
+// Put 流式保存r中的内容：边写临时文件边计算哈希，内容已存在时丢弃临时文件
+func (s *Store) Put(r io.Reader, meta Meta) (*File, error) {
+	br := bufio.NewReader(r)
+	if meta.ContentType == "" || meta.ContentType == "application/octet-stream" {
+		head, _ := br.Peek(512)
+		meta.ContentType = http.DetectContentType(head)
+	}
+
+	tmp, err := os.CreateTemp(s.tmpDir(), "upload-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), br)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{
+		ID:          newID(),
+		Name:        SanitizeFilename(meta.Name),
+		Hash:        hex.EncodeToString(h.Sum(nil)),
+		Size:        size,
+		ContentType: meta.ContentType,
+		Uploader:    meta.Uploader,
+		Width:       meta.Width,
+		Height:      meta.Height,
+		CreatedAt:   time.Now(),
+	}
+
+	if s.scan != nil && !meta.Reviewed {
+		if err := s.scan(tmp.Name(), file); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob := s.blobPath(file.Hash)
+	if _, err := os.Stat(blob); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(tmp.Name(), blob); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	s.files[file.ID] = file
+	if err := s.persist(); err != nil {
+		delete(s.files, file.ID)
+		return nil, err
+	}
+	return file, nil
+}
+
+// Get 按ID查询文件
+func (s *Store) Get(id string) (*File, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, ok := s.files[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *f
+	return &copied, nil
+}
+
+// List 按上传时间倒序返回全部文件
+func (s *Store) List() []*File {
+	s.mu.RLock()
+	files := make([]*File, 0, len(s.files))
+	for _, f := range s.files {
+		copied := *f
+		files = append(files, &copied)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].CreatedAt.Equal(files[j].CreatedAt) {
+			return files[i].ID < files[j].ID
+		}
+		return files[i].CreatedAt.After(files[j].CreatedAt)
+	})
+	return files
+}
+
+// Open 打开文件内容
+func (s *Store) Open(f *File) (*os.File, error) {
+	return os.Open(s.blobPath(f.Hash))
+}
+
+// PutThumb 保存内容的缩略图，相同内容的文件共用缩略图
+func (s *Store) PutThumb(hash string, size int, data []byte) error {
+	path := s.thumbPath(hash, size)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.tmpDir(), "thumb-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// OpenThumb 打开内容的缩略图，没有生成过时返回os.ErrNotExist
+func (s *Store) OpenThumb(hash string, size int) (*os.File, error) {
+	return os.Open(s.thumbPath(hash, size))
+}
+
+// Delete 删除文件的元数据，内容在没有引用后由GC回收
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.files, id)
+	if err := s.persist(); err != nil {
+		s.files[id] = f
+		return err
+	}
+	return nil
+}
+
+// GC 删除没有被任何文件引用的内容以及遗留的临时文件，返回删除的数量和释放的字节数
+func (s *Store) GC(tmpMaxAge time.Duration) (int, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	referenced := make(map[string]bool, len(s.files))
+	for _, f := range s.files {
+		referenced[f.Hash] = true
+	}
+
+	removed := 0
+	var freed int64
+	err := filepath.Walk(s.blobDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || referenced[info.Name()] {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	if err != nil {
+		return removed, freed, err
+	}
+
+	// 内容已被回收的缩略图
+	err = filepath.Walk(s.thumbDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() || filepath.Dir(filepath.Dir(path)) != s.thumbDir() || referenced[info.Name()] {
+			return err
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		removed++
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return removed, freed, err
+	}
+
+	// 进程中断时遗留的临时文件
+	tmps, err := os.ReadDir(s.tmpDir())
+	if err != nil {
+		return removed, freed, err
+	}
+	for _, entry := range tmps {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < tmpMaxAge {
+			continue
+		}
+		if os.Remove(filepath.Join(s.tmpDir(), entry.Name())) == nil {
+			removed++
+			freed += info.Size()
+		}
+	}
+	return removed, freed, nil
+}
+
+// persist 通过临时文件原子地保存索引，调用方需持有写锁
+func (s *Store) persist() error {
+	files := make([]*File, 0, len(s.files))
+	for _, f := range s.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ID < files[j].ID })
+
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.indexPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.indexPath())
+}
+
+func (s *Store) blobDir() string {
+	return filepath.Join(s.root, "blobs")
+}
+
+func (s *Store) thumbDir() string {
+	return filepath.Join(s.root, "thumbs")
+}
+
+func (s *Store) thumbPath(hash string, size int) string {
+	return filepath.Join(s.thumbDir(), filepath.Base(filepath.Dir(s.blobPath(hash))), strings.ToLower(hash), strconv.Itoa(size))
+}
+
+func (s *Store) tmpDir() string {
+	return filepath.Join(s.root, "tmp")
+}
+
+func (s *Store) indexPath() string {
+	return filepath.Join(s.root, "index.json")
+}
+
+// blobPath 内容的保存路径，按哈希前两位分目录避免单个目录文件过多
+func (s *Store) blobPath(hash string) string {
+	hash = strings.ToLower(hash)
+	if len(hash) < 2 {
+		return filepath.Join(s.blobDir(), "_", hash)
+	}
+	return filepath.Join(s.blobDir(), hash[:2], hash)
+}
+
+// newID 生成随机文件ID
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,121 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxNameLength 文件名的最大字节数
+const maxNameLength = 200
+
+var (
+	// ErrTooLarge 文件超过大小限制
+	ErrTooLarge = errors.New("file too large")
+
+	// ErrTooManyFiles 文件数超过限制
+	ErrTooManyFiles = errors.New("too many files")
+
+	// ErrNoFile 请求中没有文件
+	ErrNoFile = errors.New("no file uploaded")
+
+	// ErrMalformed 请求体不是合法的multipart
+	ErrMalformed = errors.New("malformed multipart body")
+)
+
+// Limits 单个路由的上传限制，0表示不限制
+type Limits struct {
+	MaxFileSize int64
+	MaxFiles    int
+}
+
+// Check 检查文件数和每个文件的大小
+func (l Limits) Check(files []*multipart.FileHeader) error {
+	if len(files) == 0 {
+		return ErrNoFile
+	}
+	if l.MaxFiles > 0 && len(files) > l.MaxFiles {
+		return fmt.Errorf("%w: %d files, limit %d", ErrTooManyFiles, len(files), l.MaxFiles)
+	}
+	for _, f := range files {
+		if l.MaxFileSize > 0 && f.Size > l.MaxFileSize {
+			return fmt.Errorf("%w: %s is %d bytes, limit %d", ErrTooLarge, f.Filename, f.Size, l.MaxFileSize)
+		}
+	}
+	return nil
+}
+
+// LimitReader 读取的内容超过max字节时返回ErrTooLarge，用于无法提前知道大小的流式上传
+func LimitReader(r io.Reader, name string, max int64) io.Reader {
+	if max <= 0 {
+		return r
+	}
+	return &limitReader{r: r, name: name, max: max}
+}
+
+type limitReader struct {
+	r    io.Reader
+	name string
+	max  int64
+	n    int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	// 多读一个字节用来判断是否超过限制
+	if remaining := l.max - l.n + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return n, fmt.Errorf("%w: %s exceeds %d bytes", ErrTooLarge, l.name, l.max)
+	}
+	return n, err
+}
+
+// SanitizeFilename 清洗客户端提供的文件名：去掉目录部分、控制字符和保留字符，
+// 避免路径穿越，结果不会为空
+func SanitizeFilename(name string) string {
+	// 客户端可能是windows，反斜杠也当作目录分隔符
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = name[strings.LastIndex(name, "/")+1:]
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == utf8.RuneError, unicode.IsControl(r):
+			return -1
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, ". ")
+
+	if len(name) > maxNameLength {
+		ext := filepath.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		name = truncate(name[:len(name)-len(ext)], maxNameLength-len(ext)) + ext
+	}
+	if name == "" {
+		name = "file"
+	}
+	return name
+}
+
+// truncate 按字节截断字符串且不截断多字节字符
+func truncate(s string, n int) string {
+	for n > 0 && n < len(s) && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	if n < len(s) {
+		return s[:n]
+	}
+	return s
+}
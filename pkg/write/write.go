@@ -1,7 +1,161 @@
 package write
 
-import "fmt"
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	. "github.com/qinchy/hellogo/gin/globalvar"
+	"github.com/qinchy/hellogo/pkg/compress"
+	"github.com/qinchy/hellogo/pkg/manifest"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
 
-func WriteFile() {
-	fmt.Println("模拟写文件。")
+// Options 输出选项
+type Options struct {
+	// Compression 输出压缩格式，为空时不压缩
+	Compression compress.Codec
 }
+
+// DefaultOptions 根据全局配置生成的默认输出选项，配置在启动时已经校验过
+func DefaultOptions() Options {
+	codec, err := compress.ParseOutputCodec(Conf.OutputCompression)
+	if err != nil {
+		Logger.Warnf("输出压缩配置无效，将不压缩：%s", err.Error())
+	}
+	return Options{Compression: codec}
+}
+
+// Sink 以JSON Lines格式流式写出记录的文件输出
+// 写出的同时计算落盘字节的SHA-256，关闭时生成清单文件。
+// 写出过程中使用临时文件，清单写好后才重命名为最终文件，下游不会读到不完整或没有清单的文件
+type Sink struct {
+	path  string
+	file  *os.File
+	hash  hash.Hash
+	size  int64
+	zw    io.WriteCloser
+	bw    *bufio.Writer
+	enc   *json.Encoder
+	count int
+	// err 第一次写出失败的错误，之后的写出直接返回该错误
+	err error
+}
+
+// Create 创建输出文件，启用压缩时会自动追加对应的扩展名
+func Create(path string, opts Options) (*Sink, error) {
+	path += opts.Compression.Ext()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	// CreateTemp创建的文件只有属主可读，输出需要给下游读取
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	s := &Sink{path: path, file: f, hash: sha256.New()}
+	zw, err := compress.NewWriter(s.out(), opts.Compression)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	s.zw = zw
+	s.bw = bufio.NewWriter(zw)
+	s.enc = json.NewEncoder(s.bw)
+	return s, nil
+}
+
+// Path 实际写出的文件路径
+func (s *Sink) Path() string {
+	return s.path
+}
+
+// Count 已写出的记录数
+func (s *Sink) Count() int {
+	return s.count
+}
+
+// Write 写出一条记录
+func (s *Sink) Write(rec interface{}) error {
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.enc.Encode(rec); err != nil {
+		s.err = err
+		return err
+	}
+	s.count++
+	return nil
+}
+
+// Err 写出过程中第一次出现的错误
+func (s *Sink) Err() error {
+	return s.err
+}
+
+// Abort 放弃输出，关闭并删除已写出的部分
+func (s *Sink) Abort() {
+	s.zw.Close()
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// Close 刷新缓冲、结束压缩流并关闭文件，成功后写出清单
+func (s *Sink) Close() error {
+	if s.err != nil {
+		s.Abort()
+		return s.err
+	}
+	err := s.bw.Flush()
+	if cerr := s.zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = manifest.Write(s.path, manifest.Manifest{
+			File:      filepath.Base(s.path),
+			Size:      s.size,
+			SHA256:    hex.EncodeToString(s.hash.Sum(nil)),
+			Records:   s.count,
+			CreatedAt: time.Now(),
+		})
+	}
+	if err == nil {
+		if err = os.Rename(s.file.Name(), s.path); err != nil {
+			os.Remove(manifest.PathFor(s.path))
+		}
+	}
+	if err != nil {
+		os.Remove(s.file.Name())
+	}
+	return err
+}
+
+// out 同时写文件、计算哈希和统计大小的writer
+func (s *Sink) out() io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		n, err := s.file.Write(p)
+		s.hash.Write(p[:n])
+		s.size += int64(n)
+		return n, err
+	})
+}
+
+// writerFunc 函数形式的io.Writer
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }